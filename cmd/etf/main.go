@@ -0,0 +1,296 @@
+// Command etf inspects and converts Erlang External Term Format frames.
+//
+//	etf json     [flags] [file ...]  decode ETF to JSON
+//	etf pack     [flags] [file ...]  encode JSON to ETF
+//	etf pretty   [flags] [file ...]  print ETF in Erlang or Elixir syntax
+//	etf validate [flags] [file ...]  check that ETF frames are well formed
+//	etf hexdump  [flags] [file ...]  print an annotated hexdump of ETF
+//
+// Each file, or standard input when none is given, holds one frame as raw
+// bytes, hex or base64. The JSON that etf pack reads is taken as is, since
+// short JSON documents such as 1234 also parse as hex or base64.
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"math/big"
+	"os"
+
+	"github.com/Azizi-X/erlpack"
+)
+
+type command struct {
+	name  string
+	usage string
+	input string // the default of the -in flag, auto when empty
+	run   func(in []byte, w io.Writer, o *options) error
+	flags func(fs *flag.FlagSet, o *options)
+}
+
+// options holds the flags of one command.
+type options struct {
+	in       string
+	out      string
+	elixir   bool
+	indent   bool
+	legacy   bool
+	compress int
+}
+
+var commands = []command{
+	{
+		name:  "json",
+		usage: "decode ETF to JSON",
+		run:   runJSON,
+	},
+	{
+		name:  "pack",
+		usage: "encode JSON to ETF",
+		input: "raw",
+		run:   runPack,
+		flags: func(fs *flag.FlagSet, o *options) {
+			fs.StringVar(&o.out, "out", "raw", "output `format`: raw, hex or base64")
+			fs.BoolVar(&o.legacy, "legacy-atoms", false, "write ASCII atoms with the latin-1 atom tags")
+			fs.IntVar(&o.compress, "compress", 0, "compress terms larger than `n` bytes")
+		},
+	},
+	{
+		name:  "pretty",
+		usage: "print ETF in Erlang syntax",
+		run:   runPretty,
+		flags: func(fs *flag.FlagSet, o *options) {
+			fs.BoolVar(&o.elixir, "elixir", false, "print Elixir syntax instead")
+			fs.BoolVar(&o.indent, "indent", false, "break large terms over several lines")
+		},
+	},
+	{
+		name:  "validate",
+		usage: "check that ETF frames are well formed",
+		run:   runValidate,
+	},
+	{
+		name:  "hexdump",
+		usage: "print an annotated hexdump of ETF",
+		run:   runHexdump,
+	},
+}
+
+func main() {
+	flag.Usage = usage
+	flag.Parse()
+	if flag.NArg() == 0 {
+		usage()
+		os.Exit(2)
+	}
+
+	name := flag.Arg(0)
+	for _, cmd := range commands {
+		if cmd.name == name {
+			os.Exit(cmd.main(flag.Args()[1:]))
+		}
+	}
+
+	fmt.Fprintf(os.Stderr, "etf: unknown command %q\n", name)
+	usage()
+	os.Exit(2)
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "usage: etf <command> [flags] [file ...]")
+	fmt.Fprintln(os.Stderr, "\ncommands:")
+	for _, cmd := range commands {
+		fmt.Fprintf(os.Stderr, "  %-10s %s\n", cmd.name, cmd.usage)
+	}
+	fmt.Fprintln(os.Stderr, "\nRun 'etf <command> -h' for the flags of a command.")
+}
+
+func (c command) main(args []string) int {
+	fs := flag.NewFlagSet("etf "+c.name, flag.ExitOnError)
+	var o options
+	input := c.input
+	if input == "" {
+		input = "auto"
+	}
+	fs.StringVar(&o.in, "in", input, "input `format`: auto, raw, hex or base64")
+	if c.flags != nil {
+		c.flags(fs, &o)
+	}
+	fs.Parse(args)
+
+	inputs := fs.Args()
+	if len(inputs) == 0 {
+		inputs = []string{"-"}
+	}
+
+	status := 0
+	for _, name := range inputs {
+		in, err := readInput(name, o.in)
+		if err == nil {
+			err = c.run(in, os.Stdout, &o)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "etf %s: %s: %v\n", c.name, displayName(name), err)
+			status = 1
+		}
+	}
+	return status
+}
+
+func displayName(name string) string {
+	if name == "-" {
+		return "<stdin>"
+	}
+	return name
+}
+
+func readInput(name, format string) ([]byte, error) {
+	var data []byte
+	var err error
+	if name == "-" {
+		data, err = io.ReadAll(os.Stdin)
+	} else {
+		data, err = os.ReadFile(name)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return decodeInput(data, format)
+}
+
+// decodeInput turns hex or base64 text into bytes. In auto mode input that
+// starts with the ETF version byte is taken as raw, and anything else is
+// tried as hex and then base64 before falling back to raw.
+func decodeInput(data []byte, format string) ([]byte, error) {
+	text := bytes.Join(bytes.Fields(data), nil)
+
+	switch format {
+	case "raw":
+		return data, nil
+	case "hex":
+		return hex.DecodeString(string(text))
+	case "base64":
+		return decodeBase64(text)
+	case "auto":
+		if len(data) > 0 && data[0] == erlpack.FORMAT_VERSION {
+			return data, nil
+		}
+		if b, err := hex.DecodeString(string(text)); err == nil {
+			return b, nil
+		}
+		if b, err := decodeBase64(text); err == nil {
+			return b, nil
+		}
+		return data, nil
+	}
+	return nil, fmt.Errorf("unknown input format %q", format)
+}
+
+func decodeBase64(text []byte) ([]byte, error) {
+	for _, enc := range []*base64.Encoding{base64.StdEncoding, base64.URLEncoding, base64.RawStdEncoding, base64.RawURLEncoding} {
+		if b, err := enc.DecodeString(string(text)); err == nil {
+			return b, nil
+		}
+	}
+	return nil, errors.New("invalid base64 input")
+}
+
+func runJSON(in []byte, w io.Writer, _ *options) error {
+	out, err := erlpack.NewDecoder().Unpack(in)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "%s\n", out)
+	return err
+}
+
+func runPack(in []byte, w io.Writer, o *options) error {
+	dec := json.NewDecoder(bytes.NewReader(in))
+	dec.UseNumber()
+
+	var v any
+	if err := dec.Decode(&v); err != nil {
+		return err
+	}
+	if _, err := dec.Token(); err != io.EOF {
+		return errors.New("trailing data after JSON value")
+	}
+
+	enc := erlpack.NewEncoder()
+	enc.LegacyAtoms = o.legacy
+	enc.CompressThreshold = o.compress
+	out, err := enc.PackE(fromJSON(v))
+	if err != nil {
+		return err
+	}
+
+	switch o.out {
+	case "raw":
+		_, err = w.Write(out)
+	case "hex":
+		_, err = fmt.Fprintf(w, "%x\n", out)
+	case "base64":
+		_, err = fmt.Fprintln(w, base64.StdEncoding.EncodeToString(out))
+	default:
+		err = fmt.Errorf("unknown output format %q", o.out)
+	}
+	return err
+}
+
+// fromJSON replaces json.Number values with integers where they are
+// integral, so that they are packed as ETF integers rather than floats.
+func fromJSON(v any) any {
+	switch v := v.(type) {
+	case json.Number:
+		if i, err := v.Int64(); err == nil {
+			return i
+		}
+		if b, ok := new(big.Int).SetString(v.String(), 10); ok {
+			return b
+		}
+		f, _ := v.Float64()
+		return f
+	case []any:
+		for i := range v {
+			v[i] = fromJSON(v[i])
+		}
+	case map[string]any:
+		for k := range v {
+			v[k] = fromJSON(v[k])
+		}
+	}
+	return v
+}
+
+func runPretty(in []byte, w io.Writer, o *options) error {
+	p := erlpack.NewPrinter()
+	p.Elixir = o.elixir
+	if o.indent {
+		p.Indent = "    "
+	}
+
+	out, err := p.Format(in)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "%s\n", out)
+	return err
+}
+
+func runValidate(in []byte, w io.Writer, _ *options) error {
+	if err := erlpack.Validate(in); err != nil {
+		return err
+	}
+	_, err := fmt.Fprintln(w, "ok")
+	return err
+}
+
+func runHexdump(in []byte, w io.Writer, _ *options) error {
+	return erlpack.WriteTrace(w, in)
+}
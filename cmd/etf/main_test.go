@@ -0,0 +1,120 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"math/big"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/Azizi-X/erlpack"
+)
+
+func TestDecodeInput(t *testing.T) {
+	frame := erlpack.NewEncoder().Pack(map[string]any{"op": 1})
+
+	for _, tt := range []struct {
+		name   string
+		in     string
+		format string
+		want   []byte
+	}{
+		{"raw frame", string(frame), "auto", frame},
+		{"hex", "8374000000016d000000026f706101", "auto", frame},
+		{"hex with spaces", "83 74000000 01\n6d00000002 6f70 6101\n", "auto", frame},
+		{"base64", "g3QAAAABbQAAAAJvcGEB", "auto", frame},
+		{"raw base64", "g3QAAAABbQAAAAJvcGEB\n", "auto", frame},
+		{"url base64", "_-8", "auto", []byte{0xff, 0xef}},
+		{"neither", "not hex!", "auto", []byte("not hex!")},
+		{"forced raw", "8361", "raw", []byte("8361")},
+		{"forced hex", "8361", "hex", []byte{0x83, 0x61}},
+		{"forced base64", "g2E=", "base64", []byte{0x83, 0x61}},
+	} {
+		got, err := decodeInput([]byte(tt.in), tt.format)
+		if err != nil || !bytes.Equal(got, tt.want) {
+			t.Errorf("%s: decodeInput = % x, %v; want % x", tt.name, got, err, tt.want)
+		}
+	}
+
+	for _, tt := range []struct{ in, format string }{
+		{"zz", "hex"},
+		{"!!", "base64"},
+		{"83", "binary"},
+	} {
+		if _, err := decodeInput([]byte(tt.in), tt.format); err == nil {
+			t.Errorf("decodeInput(%q, %s): no error", tt.in, tt.format)
+		}
+	}
+}
+
+func TestFromJSON(t *testing.T) {
+	huge, _ := new(big.Int).SetString("123456789012345678901234567890", 10)
+
+	var out bytes.Buffer
+	in := `{"int": 1, "neg": -9223372036854775808, "big": 123456789012345678901234567890,
+		"float": 1.5, "exp": 1e3, "list": [2, -3.25]}`
+	if err := runPack([]byte(in), &out, &options{out: "raw"}); err != nil {
+		t.Fatal(err)
+	}
+	got, err := erlpack.NewDecoder().UnpackValue(out.Bytes())
+	want := map[string]any{
+		"int":   int64(1),
+		"neg":   int64(-1 << 63),
+		"big":   huge,
+		"float": 1.5,
+		"exp":   1000.0,
+		"list":  []any{int64(2), -3.25},
+	}
+	if err != nil || !reflect.DeepEqual(got, want) {
+		t.Errorf("packed %s as %#v, %v; want %#v", in, got, err, want)
+	}
+}
+
+func TestPackRoundTrip(t *testing.T) {
+	for _, tt := range []struct{ in, want string }{
+		{`{"op":0,"d":{"user":{"id":"1","bot":true},"guilds":[]},"s":null}`, ""},
+		{`[1,-2,3.5,"text",false]`, ""},
+		// Bignums wider than 4 bytes come back quoted.
+		{`18446744073709551616`, `"18446744073709551616"`},
+	} {
+		in, want := tt.in, tt.want
+		if want == "" {
+			want = in
+		}
+		for _, format := range []string{"raw", "hex", "base64"} {
+			var packed, out bytes.Buffer
+			if err := runPack([]byte(in), &packed, &options{out: format}); err != nil {
+				t.Errorf("%s -out %s: %v", in, format, err)
+				continue
+			}
+			frame, err := decodeInput(packed.Bytes(), "auto")
+			if err == nil {
+				err = runJSON(frame, &out, &options{})
+			}
+			if got := strings.TrimSpace(out.String()); err != nil || !jsonEqual(got, want) {
+				t.Errorf("%s -out %s: json = %s, %v", in, format, got, err)
+			}
+		}
+	}
+}
+
+// jsonEqual compares JSON documents regardless of object member order.
+func jsonEqual(a, b string) bool {
+	var va, vb any
+	if json.Unmarshal([]byte(a), &va) != nil || json.Unmarshal([]byte(b), &vb) != nil {
+		return false
+	}
+	return reflect.DeepEqual(va, vb)
+}
+
+func TestPackTrailingData(t *testing.T) {
+	for _, in := range []string{`{} {}`, `1 2`, `[1]]`, `{"a":1} x`} {
+		if err := runPack([]byte(in), &bytes.Buffer{}, &options{out: "raw"}); err == nil {
+			t.Errorf("runPack(%s): no error", in)
+		}
+	}
+	if err := runPack([]byte("{}\n"), &bytes.Buffer{}, &options{out: "raw"}); err != nil {
+		t.Errorf("runPack with a trailing newline: %v", err)
+	}
+}
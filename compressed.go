@@ -0,0 +1,124 @@
+package erlpack
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"errors"
+	"io"
+	"sync"
+)
+
+var (
+	DefaultMaxInflateSize = 64 * 1024 * 1024
+
+	ErrInflateTooLarge  = errors.New("compressed term exceeds inflate limit")
+	ErrInflateSize      = errors.New("compressed term size mismatch")
+	ErrNestedCompressed = errors.New("compressed term below top level")
+
+	zlibWriters = sync.Pool{
+		New: func() any {
+			return zlib.NewWriter(nil)
+		},
+	}
+)
+
+// decodeFrame runs fn over the term of a frame set up by begin, inflating
+// it first if the frame is COMPRESSED. term_to_binary only compresses whole
+// terms, so COMPRESSED anywhere else is rejected with ErrNestedCompressed;
+// this keeps a frame to a single MaxInflateSize budget.
+func (d *Decoder) decodeFrame(fn func() error) error {
+	if d.offset >= len(d.data) || d.data[d.offset] != COMPRESSED {
+		return fn()
+	}
+	d.offset++
+	if err := d.decodeCompressed(fn); err != nil {
+		return d.fail(err, COMPRESSED, tagNames[COMPRESSED])
+	}
+	return nil
+}
+
+// decodeCompressed inflates a COMPRESSED term and runs fn over the
+// uncompressed bytes as if they had appeared inline.
+func (d *Decoder) decodeCompressed(fn func() error) error {
+	out, end, err := d.inflate()
+	if err != nil {
+		return err
+	}
+
+	data := d.data
+	d.data, d.offset = out, 0
+
+	err = fn()
+	if err == nil && d.offset != len(out) {
+		err = ErrInflateSize
+	}
+
+	d.data, d.offset = data, end
+	return err
+}
+
+// inflate reads the body of a COMPRESSED term and returns the inflated
+// term along with the offset just past the compressed data.
+func (d *Decoder) inflate() ([]byte, int, error) {
+	size, err := d.read32()
+	if err != nil {
+		return nil, 0, err
+	}
+
+	if d.MaxInflateSize > 0 && size > uint32(d.MaxInflateSize) {
+		return nil, 0, ErrInflateTooLarge
+	}
+
+	src := bytes.NewReader(d.data[d.offset:])
+	zr, err := zlib.NewReader(src)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer zr.Close()
+
+	out, err := inflateExact(zr, size)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return out, len(d.data) - src.Len(), nil
+}
+
+// inflateExact reads the whole zlib stream, which also verifies its
+// checksum, and checks that it holds exactly size bytes. The output grows
+// with the data actually inflated rather than the declared size.
+func inflateExact(zr io.Reader, size uint32) ([]byte, error) {
+	out, err := io.ReadAll(io.LimitReader(zr, int64(size)+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(out) != int(size) {
+		return nil, ErrInflateSize
+	}
+	return out, nil
+}
+
+func (*Encoder) compress(dst []byte, start int) ([]byte, error) {
+	var buf bytes.Buffer
+
+	zw := zlibWriters.Get().(*zlib.Writer)
+	defer zlibWriters.Put(zw)
+
+	zw.Reset(&buf)
+	if _, err := zw.Write(dst[start:]); err != nil {
+		return nil, err
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+
+	size := len(dst) - start
+	if buf.Len()+5 >= size {
+		return dst, nil
+	}
+
+	dst = append(dst[:start], COMPRESSED)
+	dst = binary.BigEndian.AppendUint32(dst, uint32(size))
+	return append(dst, buf.Bytes()...), nil
+}
@@ -0,0 +1,107 @@
+package erlpack
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+)
+
+func compressedFrame(t testing.TB) ([]byte, string) {
+	t.Helper()
+
+	text := strings.Repeat("compressed ", 100)
+	e := NewEncoder()
+	e.CompressThreshold = 64
+	frame := e.Pack(Tuple{Atom("ok"), text})
+	if frame[1] != COMPRESSED {
+		t.Fatalf("Pack did not compress: % x", frame[:8])
+	}
+	return frame, text
+}
+
+// compressTerm wraps term, which may itself be COMPRESSED, in a
+// COMPRESSED frame.
+func compressTerm(t *testing.T, term []byte) []byte {
+	t.Helper()
+
+	var buf bytes.Buffer
+	zw := zlib.NewWriter(&buf)
+	if _, err := zw.Write(term); err != nil {
+		t.Fatal(err)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	frame := []byte{FORMAT_VERSION, COMPRESSED}
+	frame = binary.BigEndian.AppendUint32(frame, uint32(len(term)))
+	return append(frame, buf.Bytes()...)
+}
+
+func TestCompressedFrame(t *testing.T) {
+	frame, text := compressedFrame(t)
+
+	out, err := NewDecoder().Unpack(frame)
+	if want := `["ok","` + text + `"]`; err != nil || string(out) != want {
+		t.Errorf("Unpack = %.40s, %v", out, err)
+	}
+	if v, err := NewDecoder().UnpackValue(frame); err != nil || len(v.(Tuple)) != 2 {
+		t.Errorf("UnpackValue = %.40v, %v", v, err)
+	}
+	if n, err := Skip(frame); err != nil || n != len(frame) {
+		t.Errorf("Skip = %d, %v; want %d", n, err, len(frame))
+	}
+	if raw, err := Get(frame, "0"); err != nil || !bytes.Equal(raw, []byte{SMALL_ATOM_UTF8_EXT, 2, 'o', 'k'}) {
+		t.Errorf("Get = % x, %v", raw, err)
+	}
+	if _, err := ParseTerm(frame); err != nil {
+		t.Errorf("ParseTerm: %v", err)
+	}
+	if _, err := Trace(frame); err != nil {
+		t.Errorf("Trace: %v", err)
+	}
+	if _, err := NewStreamDecoder(bytes.NewReader(frame)).Decode(); err != nil {
+		t.Errorf("StreamDecoder: %v", err)
+	}
+
+	r, err := NewReader(frame)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for err == nil {
+		_, err = r.Next()
+	}
+	if err != io.EOF {
+		t.Errorf("Reader: %v", err)
+	}
+}
+
+func TestNestedCompressed(t *testing.T) {
+	frame, _ := compressedFrame(t)
+	// The compressed term as the element of a tuple.
+	nested := append([]byte{FORMAT_VERSION, SMALL_TUPLE_EXT, 1}, frame[1:]...)
+
+	checks := decoders(NewDecoder(), nested, "0")
+	checks["Get/descend"] = func() error {
+		_, err := Get(nested, "0", "0")
+		return err
+	}
+	checks["Trace"] = func() error {
+		_, err := Trace(nested)
+		return err
+	}
+	checks["doubly compressed"] = func() error {
+		_, err := NewDecoder().Unpack(compressTerm(t, frame[1:]))
+		return err
+	}
+
+	for name, check := range checks {
+		if err := check(); !errors.Is(err, ErrNestedCompressed) {
+			t.Errorf("%s: %v, want ErrNestedCompressed", name, err)
+		}
+	}
+}
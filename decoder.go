@@ -3,26 +3,40 @@ package erlpack
 import (
 	"encoding/binary"
 	"errors"
+	"fmt"
 	"math"
+	"math/big"
 	"strconv"
 )
 
 const (
-	SMALL_INTEGER_EXT = 97
-	INTEGER_EXT       = 98
-	FLOAT_EXT         = 99
-	ATOM_EXT          = 100
-	SMALL_ATOM_EXT    = 115
-	SMALL_TUPLE_EXT   = 104
-	LARGE_TUPLE_EXT   = 105
-	NIL_EXT           = 106
-	STRING_EXT        = 107
-	LIST_EXT          = 108
-	MAP_EXT           = 116
-	BINARY_EXT        = 109
-	SMALL_BIG_EXT     = 110
-	LARGE_BIG_EXT     = 111
-	NEW_FLOAT_EXT     = 70
+	SMALL_INTEGER_EXT   = 97
+	INTEGER_EXT         = 98
+	FLOAT_EXT           = 99
+	ATOM_EXT            = 100
+	SMALL_ATOM_EXT      = 115
+	ATOM_UTF8_EXT       = 118
+	SMALL_ATOM_UTF8_EXT = 119
+	SMALL_TUPLE_EXT     = 104
+	LARGE_TUPLE_EXT     = 105
+	NIL_EXT             = 106
+	STRING_EXT          = 107
+	LIST_EXT            = 108
+	MAP_EXT             = 116
+	BINARY_EXT          = 109
+	SMALL_BIG_EXT       = 110
+	LARGE_BIG_EXT       = 111
+	NEW_FLOAT_EXT       = 70
+	COMPRESSED          = 80
+	NEW_PID_EXT         = 88
+	PID_EXT             = 103
+	NEW_PORT_EXT        = 89
+	V4_PORT_EXT         = 120
+	PORT_EXT            = 102
+	NEWER_REFERENCE_EXT = 90
+	NEW_REFERENCE_EXT   = 114
+	NEW_FUN_EXT         = 112
+	EXPORT_EXT          = 113
 
 	FORMAT_VERSION = 131
 )
@@ -30,37 +44,196 @@ const (
 var (
 	hexMap = [16]byte{'0', '1', '2', '3', '4', '5', '6', '7', '8', '9', 'a', 'b', 'c', 'd', 'e', 'f'}
 
-	errInvalidFormat      = errors.New("invalid format")
-	errListTailMissing    = errors.New("list tail missing")
-	errUnsupportedTag     = errors.New("unsupported tag")
-	errUnsupportedKeyTag  = errors.New("unsupported key tag")
-	errTooBig             = errors.New("unable to decode big ints larger than 8 bytes")
-	errRead8OutOfBound    = errors.New("read8 out of bounds")
-	errRead16OutOfBound   = errors.New("read16 out of bounds")
-	errRead32OutOfBound   = errors.New("read32 out of bounds")
-	errRead64OutOfBound   = errors.New("read64 out of bounds")
-	errReadByteOutOfBound = errors.New("ready byte out of bounds")
+	ErrInvalidFormat      = errors.New("invalid format")
+	ErrListTailMissing    = errors.New("list tail missing")
+	ErrUnsupportedTag     = errors.New("unsupported tag")
+	ErrUnsupportedKeyTag  = errors.New("unsupported key tag")
+	ErrRead8OutOfBound    = errors.New("read8 out of bounds")
+	ErrRead16OutOfBound   = errors.New("read16 out of bounds")
+	ErrRead32OutOfBound   = errors.New("read32 out of bounds")
+	ErrRead64OutOfBound   = errors.New("read64 out of bounds")
+	ErrReadByteOutOfBound = errors.New("read byte out of bounds")
+	ErrLengthExceedsInput = errors.New("declared length exceeds remaining input")
+	ErrMaxDepth           = errors.New("maximum nesting depth exceeded")
+	ErrMaxElements        = errors.New("maximum container size exceeded")
+	ErrMaxBinarySize      = errors.New("maximum binary size exceeded")
+	ErrMaxOutputSize      = errors.New("maximum output size exceeded")
+
+	DefaultMaxDepth = 10000
 
 	MaxCap = 32 * 1024
 )
 
+var tagNames = [256]string{
+	SMALL_INTEGER_EXT:   "SMALL_INTEGER_EXT",
+	INTEGER_EXT:         "INTEGER_EXT",
+	FLOAT_EXT:           "FLOAT_EXT",
+	ATOM_EXT:            "ATOM_EXT",
+	SMALL_ATOM_EXT:      "SMALL_ATOM_EXT",
+	ATOM_UTF8_EXT:       "ATOM_UTF8_EXT",
+	SMALL_ATOM_UTF8_EXT: "SMALL_ATOM_UTF8_EXT",
+	SMALL_TUPLE_EXT:     "SMALL_TUPLE_EXT",
+	LARGE_TUPLE_EXT:     "LARGE_TUPLE_EXT",
+	NIL_EXT:             "NIL_EXT",
+	STRING_EXT:          "STRING_EXT",
+	LIST_EXT:            "LIST_EXT",
+	MAP_EXT:             "MAP_EXT",
+	BINARY_EXT:          "BINARY_EXT",
+	SMALL_BIG_EXT:       "SMALL_BIG_EXT",
+	LARGE_BIG_EXT:       "LARGE_BIG_EXT",
+	NEW_FLOAT_EXT:       "NEW_FLOAT_EXT",
+	COMPRESSED:          "COMPRESSED",
+	NEW_PID_EXT:         "NEW_PID_EXT",
+	PID_EXT:             "PID_EXT",
+	NEW_PORT_EXT:        "NEW_PORT_EXT",
+	V4_PORT_EXT:         "V4_PORT_EXT",
+	PORT_EXT:            "PORT_EXT",
+	NEWER_REFERENCE_EXT: "NEWER_REFERENCE_EXT",
+	NEW_REFERENCE_EXT:   "NEW_REFERENCE_EXT",
+	NEW_FUN_EXT:         "NEW_FUN_EXT",
+	EXPORT_EXT:          "EXPORT_EXT",
+	FORMAT_VERSION:      "FORMAT_VERSION",
+}
+
+// DecodeError describes where decoding failed. Offset counts from the start
+// of the frame including the version byte; in a COMPRESSED frame it counts
+// into the inflated term instead. Path is a JSON pointer to the
+// term that failed, such as /d/members/3/user.
+type DecodeError struct {
+	Offset   int
+	Tag      byte
+	Expected string
+	Path     string
+	Err      error
+}
+
+func (e *DecodeError) Error() string {
+	path := e.Path
+	if path == "" {
+		path = "/"
+	}
+	return fmt.Sprintf("%v: reading %s (tag %d) at offset %d, path %s", e.Err, e.Expected, e.Tag, e.Offset, path)
+}
+
+func (e *DecodeError) Unwrap() error {
+	return e.Err
+}
+
+func (d *Decoder) fail(err error, tag byte, expected string) error {
+	if _, ok := err.(*DecodeError); ok {
+		return err
+	}
+	return &DecodeError{Offset: d.offset + 1, Tag: tag, Expected: expected, Err: err}
+}
+
+func (d *Decoder) failKey(err error, off int) error {
+	var tag byte
+	if off < len(d.data) {
+		tag = d.data[off]
+	}
+	return d.fail(err, tag, "map key")
+}
+
+// keyAt re-reads the map key at off to name it in an error path. It is
+// only used once decoding has already failed.
+func (d *Decoder) keyAt(off int) string {
+	saved := d.offset
+	d.offset = off
+	key, _ := d.decodeKey()
+	d.offset = saved
+	return string(key)
+}
+
+func decodePath(err error, key string) error {
+	if de, ok := err.(*DecodeError); ok {
+		de.Path = "/" + pathEscaper.Replace(key) + de.Path
+	}
+	return err
+}
+
 type Decoder struct {
 	data    []byte
 	offset  int
+	depth   int
 	buf     []byte
 	tempBuf []byte
+
+	// TupleKey, when set, wraps decoded tuples in an object with a single
+	// TupleKey member so they can be told apart from lists. Tuple map keys
+	// are wrapped the same way before being written as key strings.
+	TupleKey string
+
+	// BigAsNumber emits every bignum as a bare JSON number. By default
+	// bignums wider than 4 bytes are quoted so JavaScript consumers do not
+	// lose precision.
+	BigAsNumber bool
+
+	// MaxInflateSize caps the declared uncompressed size of a COMPRESSED
+	// frame, and the inflated size of a ZlibStream message. Zero disables
+	// the check.
+	MaxInflateSize int
+
+	// MaxDepth limits how deeply lists, tuples and maps may nest.
+	// MaxElements limits the declared length of a single container.
+	// MaxBinarySize limits the length of atoms, binaries, strings and
+	// bignums.
+	// MaxOutputSize limits the size of the JSON produced by Unpack.
+	// Zero disables a limit.
+	MaxDepth      int
+	MaxElements   int
+	MaxBinarySize int
+	MaxOutputSize int
 }
 
 func NewDecoder() *Decoder {
+	d := newScanDecoder()
+	d.tempBuf = make([]byte, 0, 32)
+	d.buf = make([]byte, 0, MaxCap)
+	return d
+}
+
+// newScanDecoder returns a decoder with the default limits but without
+// the output buffers. Walks that produce no JSON never need them, and
+// Unpack sizes the output buffer from the first frame it is given, so
+// one-shot callers don't pay for MaxCap up front.
+func newScanDecoder() *Decoder {
 	return &Decoder{
-		tempBuf: make([]byte, 0, 32),
-		buf:     make([]byte, 0, MaxCap),
+		MaxInflateSize: DefaultMaxInflateSize,
+		MaxDepth:       DefaultMaxDepth,
 	}
 }
 
+// enter accounts for one more level of nesting and checks that a container
+// of n elements, each at least size bytes long, fits the limits and the
+// remaining input before anything is allocated for it.
+func (d *Decoder) enter(n uint32, size int) error {
+	if d.MaxDepth > 0 && d.depth >= d.MaxDepth {
+		return ErrMaxDepth
+	}
+	if err := d.checkLength(n, size); err != nil {
+		return err
+	}
+	d.depth++
+	return nil
+}
+
+func (d *Decoder) checkLength(n uint32, size int) error {
+	if d.MaxElements > 0 && uint64(n) > uint64(d.MaxElements) {
+		return ErrMaxElements
+	}
+	if uint64(n)*uint64(size) > uint64(len(d.data)-d.offset) {
+		return ErrLengthExceedsInput
+	}
+	return nil
+}
+
+func (d *Decoder) leave() {
+	d.depth--
+}
+
 func (d *Decoder) read8() (uint8, error) {
 	if d.offset+1 > len(d.data) {
-		return 0, errRead8OutOfBound
+		return 0, ErrRead8OutOfBound
 	}
 	v := d.data[d.offset]
 	d.offset++
@@ -69,7 +242,7 @@ func (d *Decoder) read8() (uint8, error) {
 
 func (d *Decoder) read16() (uint16, error) {
 	if d.offset+2 > len(d.data) {
-		return 0, errRead16OutOfBound
+		return 0, ErrRead16OutOfBound
 	}
 	v := binary.BigEndian.Uint16(d.data[d.offset:])
 	d.offset += 2
@@ -78,7 +251,7 @@ func (d *Decoder) read16() (uint16, error) {
 
 func (d *Decoder) read32() (uint32, error) {
 	if d.offset+4 > len(d.data) {
-		return 0, errRead32OutOfBound
+		return 0, ErrRead32OutOfBound
 	}
 	v := binary.BigEndian.Uint32(d.data[d.offset:])
 	d.offset += 4
@@ -87,7 +260,7 @@ func (d *Decoder) read32() (uint32, error) {
 
 func (d *Decoder) read64() (uint64, error) {
 	if d.offset+8 > len(d.data) {
-		return 0, errRead64OutOfBound
+		return 0, ErrRead64OutOfBound
 	}
 	v := binary.BigEndian.Uint64(d.data[d.offset:])
 	d.offset += 8
@@ -96,13 +269,44 @@ func (d *Decoder) read64() (uint64, error) {
 
 func (d *Decoder) readBytes(n uint32) ([]byte, error) {
 	if d.offset+int(n) > len(d.data) {
-		return nil, errReadByteOutOfBound
+		return nil, ErrReadByteOutOfBound
 	}
 	b := d.data[d.offset : d.offset+int(n)]
 	d.offset += int(n)
 	return b, nil
 }
 
+// readLength reads a big-endian length prefix that is width bytes wide.
+func (d *Decoder) readLength(width int) (uint32, error) {
+	switch width {
+	case 1:
+		l, err := d.read8()
+		return uint32(l), err
+	case 2:
+		l, err := d.read16()
+		return uint32(l), err
+	default:
+		return d.read32()
+	}
+}
+
+func (d *Decoder) readBinary(n uint32) ([]byte, error) {
+	if d.MaxBinarySize > 0 && uint64(n) > uint64(d.MaxBinarySize) {
+		return nil, ErrMaxBinarySize
+	}
+	return d.readBytes(n)
+}
+
+// readSized reads a width-byte length prefix and the payload it announces.
+// Atoms, strings and binaries go through here for both values and keys.
+func (d *Decoder) readSized(width int) ([]byte, error) {
+	l, err := d.readLength(width)
+	if err != nil {
+		return nil, err
+	}
+	return d.readBinary(l)
+}
+
 func (d *Decoder) writeJsonASCII(s []byte) {
 	d.buf = append(d.buf, '"')
 	for _, c := range s {
@@ -170,25 +374,35 @@ func (d *Decoder) decodeInteger() error {
 	if err != nil {
 		return err
 	}
-	d.buf = strconv.AppendUint(d.buf, uint64(v), 10)
+	d.buf = strconv.AppendInt(d.buf, int64(int32(v)), 10)
 	return nil
 }
 
-func (d *Decoder) decodeNewFloat() error {
+// readFloat reads a NEW_FLOAT_EXT payload. Erlang has no NaN or infinity,
+// so those bit patterns are rejected rather than passed on.
+func (d *Decoder) readFloat() (float64, error) {
 	v, err := d.read64()
 	if err != nil {
-		return err
+		return 0, err
 	}
-	d.buf = strconv.AppendFloat(d.buf, math.Float64frombits(v), 'f', -1, 64)
-	return nil
+	f := math.Float64frombits(v)
+	if math.IsNaN(f) || math.IsInf(f, 0) {
+		return 0, ErrInvalidFloat
+	}
+	return f, nil
 }
 
-func (d *Decoder) decodeAtom() error {
-	l, err := d.read16()
+func (d *Decoder) decodeNewFloat() error {
+	f, err := d.readFloat()
 	if err != nil {
 		return err
 	}
-	b, err := d.readBytes(uint32(l))
+	d.buf = strconv.AppendFloat(d.buf, f, 'f', -1, 64)
+	return nil
+}
+
+func (d *Decoder) decodeAtom() error {
+	b, err := d.readSized(2)
 	if err != nil {
 		return err
 	}
@@ -197,11 +411,7 @@ func (d *Decoder) decodeAtom() error {
 }
 
 func (d *Decoder) decodeSmallAtom() error {
-	l, err := d.read8()
-	if err != nil {
-		return err
-	}
-	b, err := d.readBytes(uint32(l))
+	b, err := d.readSized(1)
 	if err != nil {
 		return err
 	}
@@ -210,11 +420,7 @@ func (d *Decoder) decodeSmallAtom() error {
 }
 
 func (d *Decoder) decodeString() error {
-	l, err := d.read16()
-	if err != nil {
-		return err
-	}
-	b, err := d.readBytes(uint32(l))
+	b, err := d.readSized(2)
 	if err != nil {
 		return err
 	}
@@ -223,11 +429,7 @@ func (d *Decoder) decodeString() error {
 }
 
 func (d *Decoder) decodeBinary() error {
-	l, err := d.read32()
-	if err != nil {
-		return err
-	}
-	b, err := d.readBytes(l)
+	b, err := d.readSized(4)
 	if err != nil {
 		return err
 	}
@@ -236,26 +438,56 @@ func (d *Decoder) decodeBinary() error {
 }
 
 func (d *Decoder) decodeArray(n uint32) error {
+	if err := d.enter(n, 1); err != nil {
+		return err
+	}
 	d.buf = append(d.buf, '[')
 	for i := range n {
 		if i > 0 {
 			d.buf = append(d.buf, ',')
 		}
 		if err := d.decode(); err != nil {
-			return err
+			return decodePath(err, strconv.FormatUint(uint64(i), 10))
 		}
 	}
 	d.buf = append(d.buf, ']')
+	d.leave()
 	return nil
 }
 
+func (d *Decoder) decodeTuple(width int) error {
+	n, err := d.readLength(width)
+	if err != nil {
+		return err
+	}
+	if d.TupleKey == "" {
+		return d.decodeArray(n)
+	}
+	d.buf = append(d.buf, '{')
+	d.writeJsonASCII([]byte(d.TupleKey))
+	d.buf = append(d.buf, ':')
+	if err := d.decodeArray(n); err != nil {
+		return err
+	}
+	d.buf = append(d.buf, '}')
+	return nil
+}
+
+func (d *Decoder) decodeSmallTuple() error {
+	return d.decodeTuple(1)
+}
+
+func (d *Decoder) decodeLargeTuple() error {
+	return d.decodeTuple(4)
+}
+
 func (d *Decoder) decodeNil() error {
 	d.buf = append(d.buf, '[', ']')
 	return nil
 }
 
 func (d *Decoder) decodeList() error {
-	l, err := d.read32()
+	l, err := d.readLength(4)
 	if err != nil {
 		return err
 	}
@@ -264,32 +496,37 @@ func (d *Decoder) decodeList() error {
 	}
 	tail, err := d.read8()
 	if err != nil || tail != NIL_EXT {
-		return errListTailMissing
+		return d.fail(ErrListTailMissing, tail, "list tail")
 	}
 	return nil
 }
 
 func (d *Decoder) decodeMap() error {
-	l, err := d.read32()
+	l, err := d.readLength(4)
 	if err != nil {
 		return err
 	}
+	if err := d.enter(l, 2); err != nil {
+		return err
+	}
 	d.buf = append(d.buf, '{')
 	for i := range l {
 		if i > 0 {
 			d.buf = append(d.buf, ',')
 		}
+		keyOff := d.offset
 		key, err := d.decodeKey()
 		if err != nil {
-			return err
+			return d.failKey(err, keyOff)
 		}
 		d.writeJsonASCII(key)
 		d.buf = append(d.buf, ':')
 		if err := d.decode(); err != nil {
-			return err
+			return decodePath(err, d.keyAt(keyOff))
 		}
 	}
 	d.buf = append(d.buf, '}')
+	d.leave()
 	return nil
 }
 
@@ -300,161 +537,218 @@ func (d *Decoder) decodeKey() ([]byte, error) {
 	}
 
 	switch tag {
-	case ATOM_EXT:
-		l, _ := d.read16()
-		return d.readBytes(uint32(l))
-	case SMALL_ATOM_EXT:
-		l, _ := d.read8()
-		return d.readBytes(uint32(l))
+	case ATOM_EXT, ATOM_UTF8_EXT, STRING_EXT:
+		return d.readSized(2)
+	case SMALL_ATOM_EXT, SMALL_ATOM_UTF8_EXT:
+		return d.readSized(1)
 	case BINARY_EXT:
-		l, _ := d.read32()
-		return d.readBytes(l)
-	case STRING_EXT:
-		l, _ := d.read16()
-		return d.readBytes(uint32(l))
+		return d.readSized(4)
 	case SMALL_INTEGER_EXT:
-		_, err := d.read8()
+		v, err := d.read8()
 		if err != nil {
 			return nil, err
 		}
 
-		b := d.data[d.offset-1]
 		d.tempBuf = d.tempBuf[:0]
-		d.tempBuf = strconv.AppendUint(d.tempBuf, uint64(b), 10)
+		d.tempBuf = strconv.AppendUint(d.tempBuf, uint64(v), 10)
 
 		return d.tempBuf, nil
 	case SMALL_BIG_EXT:
-		digits, err := d.read8()
-		if err != nil {
-			return nil, err
-		}
+		return d.decodeBigKey(1)
+	case LARGE_BIG_EXT:
+		return d.decodeBigKey(4)
+	case SMALL_TUPLE_EXT:
+		return d.decodeTupleKey(1)
+	case LARGE_TUPLE_EXT:
+		return d.decodeTupleKey(4)
+	default:
+		return nil, ErrUnsupportedKeyTag
+	}
+}
 
-		number, err := d.decodeBigRaw(uint32(digits))
-		if err != nil {
-			return nil, err
-		}
+func (d *Decoder) decodeBigKey(width int) ([]byte, error) {
+	neg, mag, err := d.decodeBigRaw(width)
+	if err != nil {
+		return nil, err
+	}
 
-		d.tempBuf = d.tempBuf[:0]
-		d.tempBuf = strconv.AppendInt(d.tempBuf, number, 10)
+	d.tempBuf = appendBig(d.tempBuf[:0], neg, mag)
 
-		return d.tempBuf, nil
-	default:
-		return nil, errUnsupportedKeyTag
+	return d.tempBuf, nil
+}
+
+// decodeTupleKey renders a tuple key as its JSON text, since JSON object
+// keys can only be strings. The text is what the tuple decodes to as a
+// value, so TupleKey applies to the key's outer tuple as well.
+func (d *Decoder) decodeTupleKey(width int) ([]byte, error) {
+	start := len(d.buf)
+	if err := d.decodeTuple(width); err != nil {
+		d.buf = d.buf[:start]
+		return nil, err
 	}
+	d.tempBuf = append(d.tempBuf[:0], d.buf[start:]...)
+	d.buf = d.buf[:start]
+	return d.tempBuf, nil
 }
 
 func (d *Decoder) decodeSmallBig() error {
-	bytes, err := d.read8()
-	if err != nil {
-		return err
-	}
-	return d.decodeBig(uint32(bytes))
+	return d.decodeBig(1)
 }
 
 func (d *Decoder) decodeLargeBig() error {
-	bytes, err := d.read32()
-	if err != nil {
-		return err
-	}
-	return d.decodeBig(bytes)
+	return d.decodeBig(4)
 }
 
 func (d *Decoder) decode() error {
+	if d.MaxOutputSize > 0 && len(d.buf) > d.MaxOutputSize {
+		return d.fail(ErrMaxOutputSize, 0, "term")
+	}
+
+	start := d.offset
 	tag, err := d.read8()
 	if err != nil {
-		return err
+		return d.fail(err, 0, "tag")
 	}
 	switch tag {
 	case SMALL_INTEGER_EXT:
-		return d.decodeSmallInteger()
+		err = d.decodeSmallInteger()
 	case INTEGER_EXT:
-		return d.decodeInteger()
+		err = d.decodeInteger()
 	case NEW_FLOAT_EXT:
-		return d.decodeNewFloat()
-	case ATOM_EXT:
-		return d.decodeAtom()
-	case SMALL_ATOM_EXT:
-		return d.decodeSmallAtom()
+		err = d.decodeNewFloat()
+	case ATOM_EXT, ATOM_UTF8_EXT:
+		err = d.decodeAtom()
+	case SMALL_ATOM_EXT, SMALL_ATOM_UTF8_EXT:
+		err = d.decodeSmallAtom()
 	case STRING_EXT:
-		return d.decodeString()
+		err = d.decodeString()
 	case LIST_EXT:
-		return d.decodeList()
+		err = d.decodeList()
+	case SMALL_TUPLE_EXT:
+		err = d.decodeSmallTuple()
+	case LARGE_TUPLE_EXT:
+		err = d.decodeLargeTuple()
 	case MAP_EXT:
-		return d.decodeMap()
+		err = d.decodeMap()
 	case NIL_EXT:
-		return d.decodeNil()
+		err = d.decodeNil()
 	case BINARY_EXT:
-		return d.decodeBinary()
+		err = d.decodeBinary()
 	case SMALL_BIG_EXT:
-		return d.decodeSmallBig()
+		err = d.decodeSmallBig()
 	case LARGE_BIG_EXT:
-		return d.decodeLargeBig()
+		err = d.decodeLargeBig()
+	case COMPRESSED:
+		err = ErrNestedCompressed
 	default:
-		return errUnsupportedTag
+		d.offset = start
+		return d.fail(ErrUnsupportedTag, tag, "term")
+	}
+	if err != nil {
+		return d.fail(err, tag, tagNames[tag])
 	}
+	return nil
 }
 
-func (d *Decoder) decodeBigRaw(digits uint32) (int64, error) {
+func (d *Decoder) decodeBigRaw(width int) (bool, []byte, error) {
+	digits, err := d.readLength(width)
+	if err != nil {
+		return false, nil, err
+	}
+
 	sign, err := d.read8()
 	if err != nil {
-		return 0, err
+		return false, nil, err
 	}
 
-	if digits > 8 {
-		return 0, errTooBig
+	mag, err := d.readBinary(digits)
+	if err != nil {
+		return false, nil, err
 	}
 
-	var value int64
-	var b uint64 = 1
-	for range digits {
-		digit, err := d.read8()
-		if err != nil {
-			return 0, err
-		}
-		value += int64(uint64(digit) * b)
-		b <<= 8
+	return sign != 0, mag, nil
+}
+
+// bigUint64 returns the little-endian bignum magnitude as a uint64 when
+// it fits in 64 bits.
+func bigUint64(mag []byte) (uint64, bool) {
+	for len(mag) > 8 && mag[len(mag)-1] == 0 {
+		mag = mag[:len(mag)-1]
+	}
+	if len(mag) > 8 {
+		return 0, false
 	}
 
-	if digits <= 4 {
-		if sign == 0 {
-			return value, nil
-		}
-		return -value, nil
+	var value uint64
+	for i := len(mag) - 1; i >= 0; i-- {
+		value = value<<8 | uint64(mag[i])
 	}
+	return value, true
+}
 
-	if sign != 0 {
-		return -value, nil
+func newBigInt(neg bool, mag []byte) *big.Int {
+	be := make([]byte, len(mag))
+	for i, b := range mag {
+		be[len(mag)-1-i] = b
 	}
 
-	return value, nil
+	value := new(big.Int).SetBytes(be)
+	if neg {
+		value.Neg(value)
+	}
+	return value
 }
 
-func (d *Decoder) decodeBig(digits uint32) error {
-	value, err := d.decodeBigRaw(digits)
+func appendBig(dst []byte, neg bool, mag []byte) []byte {
+	if value, ok := bigUint64(mag); ok {
+		if neg && value != 0 {
+			dst = append(dst, '-')
+		}
+		return strconv.AppendUint(dst, value, 10)
+	}
+	return newBigInt(neg, mag).Append(dst, 10)
+}
+
+func (d *Decoder) decodeBig(width int) error {
+	neg, mag, err := d.decodeBigRaw(width)
 	if err != nil {
 		return err
 	}
 
-	if digits <= 4 {
-		d.buf = strconv.AppendInt(d.buf, value, 10)
+	if d.BigAsNumber || len(mag) <= 4 {
+		d.buf = appendBig(d.buf, neg, mag)
 		return nil
 	}
 
 	d.buf = append(d.buf, '"')
-	d.buf = strconv.AppendInt(d.buf, value, 10)
+	d.buf = appendBig(d.buf, neg, mag)
 	d.buf = append(d.buf, '"')
 
 	return nil
 }
 
-func (d *Decoder) Unpack(data []byte) ([]byte, error) {
+// begin points the decoder at a version-prefixed frame.
+func (d *Decoder) begin(data []byte) error {
 	if len(data) == 0 || data[0] != FORMAT_VERSION {
-		return nil, errInvalidFormat
+		de := &DecodeError{Expected: "version", Err: ErrInvalidFormat}
+		if len(data) > 0 {
+			de.Tag = data[0]
+		}
+		return de
 	}
 
 	d.offset = 0
+	d.depth = 0
 	d.data = data[1:]
 
+	return nil
+}
+
+func (d *Decoder) Unpack(data []byte) ([]byte, error) {
+	if err := d.begin(data); err != nil {
+		return nil, err
+	}
+
 	if cap(d.buf) > MaxCap {
 		d.buf = nil
 		d.buf = make([]byte, 0, MaxCap)
@@ -466,9 +760,13 @@ func (d *Decoder) Unpack(data []byte) ([]byte, error) {
 		d.buf = make([]byte, 0, len(d.data)*2)
 	}
 
-	if err := d.decode(); err != nil {
+	if err := d.decodeFrame(d.decode); err != nil {
 		return nil, err
 	}
 
+	if d.MaxOutputSize > 0 && len(d.buf) > d.MaxOutputSize {
+		return nil, d.fail(ErrMaxOutputSize, 0, "term")
+	}
+
 	return d.buf, nil
 }
@@ -0,0 +1,467 @@
+package erlpack
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"math/big"
+	"slices"
+	"strings"
+	"testing"
+)
+
+type readyUser struct {
+	ID       string `json:"id"`
+	Username string `json:"username"`
+	Bot      bool   `json:"bot"`
+	Flags    int64  `json:"flags"`
+}
+
+type readyGuild struct {
+	ID          string `json:"id"`
+	Unavailable bool   `json:"unavailable"`
+}
+
+type ready struct {
+	V         int          `json:"v"`
+	User      readyUser    `json:"user"`
+	Guilds    []readyGuild `json:"guilds"`
+	SessionID string       `json:"session_id"`
+	Shard     Tuple        `json:"shard"`
+	Ratio     float64      `json:"ratio"`
+	Trace     []any        `json:"_trace"`
+}
+
+// testFrames are well-formed frames covering every tag the JSON decoder
+// accepts and every kind of map key.
+func testFrames() [][]byte {
+	e := NewEncoder()
+	huge, _ := new(big.Int).SetString("-123456789012345678901234567890", 10)
+	legacy := NewEncoder()
+	legacy.LegacyAtoms = true
+
+	return [][]byte{
+		e.Pack(gatewayPayload{Op: 0, T: "READY", D: ready{
+			V:         10,
+			User:      readyUser{ID: "80351110224678912", Username: "Nelly", Flags: 1 << 40},
+			Guilds:    []readyGuild{{ID: "41771983423143937", Unavailable: true}},
+			SessionID: "4f8ab2",
+			Shard:     Tuple{0, 1},
+			Ratio:     1.5,
+			Trace:     []any{"gateway-prd-main", int64(-70000), huge},
+		}}),
+		e.Pack(Map{
+			{Key: Atom("atom"), Value: Int(1)},
+			{Key: Binary("binary"), Value: Int(2)},
+			{Key: Int(7), Value: Int(3)},
+			{Key: BigInt{huge}, Value: Int(4)},
+			{Key: Charlist("chars"), Value: Int(5)},
+			{Key: Tuple{Int(1), Atom("two")}, Value: Int(6)},
+		}),
+		legacy.Pack(Tuple{Atom("reply"), Charlist("charlist"), Atom(strings.Repeat("x", 300)), make([]any, 300)}),
+	}
+}
+
+// truncated reports whether err is one of the errors a frame cut short
+// can produce.
+func truncated(err error) bool {
+	for _, target := range []error{
+		ErrRead8OutOfBound,
+		ErrRead16OutOfBound,
+		ErrRead32OutOfBound,
+		ErrRead64OutOfBound,
+		ErrReadByteOutOfBound,
+		ErrLengthExceedsInput,
+		ErrListTailMissing,
+	} {
+		if errors.Is(err, target) {
+			return true
+		}
+	}
+	return false
+}
+
+// decoders returns every entry point that walks a whole frame, each set to
+// read data with d and report the first error it meets. Get follows path
+// and the Reader reads the tokens of one term.
+func decoders(d *Decoder, data []byte, path ...string) map[string]func() error {
+	return map[string]func() error{
+		"Unpack": func() error {
+			_, err := d.Unpack(data)
+			return err
+		},
+		"UnpackValue": func() error {
+			_, err := d.UnpackValue(data)
+			return err
+		},
+		"Skip": func() error {
+			_, err := d.Skip(data)
+			return err
+		},
+		"Validate": func() error {
+			return d.Validate(data)
+		},
+		"ParseTerm": func() error {
+			_, err := d.ParseTerm(data)
+			return err
+		},
+		"Get": func() error {
+			_, err := d.Get(data, path...)
+			return err
+		},
+		"StreamDecoder": func() error {
+			s := NewStreamDecoder(bytes.NewReader(data))
+			s.Decoder = d
+			_, err := s.Decode()
+			return err
+		},
+		"Reader": func() error {
+			r, err := d.NewReader(data)
+			for remaining := 1; err == nil && remaining > 0; remaining-- {
+				var tok Token
+				if tok, err = r.Next(); err == io.EOF {
+					err = io.ErrUnexpectedEOF
+				}
+				switch tok.Kind {
+				case KindTuple:
+					remaining += int(tok.Size)
+				case KindList:
+					remaining += int(tok.Size) + 1
+				case KindMap:
+					remaining += 2 * int(tok.Size)
+				}
+			}
+			return err
+		},
+	}
+}
+
+func TestTruncatedFrames(t *testing.T) {
+	// The last subterm of each frame, so that Get has to read up to the
+	// cut whatever it is.
+	paths := [][]string{
+		{"t"},
+		{`[1,"two"]`},
+		{"3"},
+	}
+
+	for i, frame := range testFrames() {
+		for name, decode := range decoders(NewDecoder(), frame, paths[i]...) {
+			if err := decode(); err != nil {
+				t.Fatalf("frame %d: %s: %v", i, name, err)
+			}
+		}
+
+		for n := range len(frame) {
+			for name, decode := range decoders(NewDecoder(), frame[:n], paths[i]...) {
+				err := decode()
+				var de *DecodeError
+				switch {
+				case name == "StreamDecoder" && n == 0 && err == io.EOF:
+				case (name == "StreamDecoder" || name == "Reader") && err == io.ErrUnexpectedEOF:
+					// Both stop at the end of a token, outside any
+					// DecodeError.
+				case !errors.As(err, &de):
+					t.Errorf("frame %d[:%d]: %s = %v, want a DecodeError", i, n, name, err)
+				case n == 0 && !errors.Is(err, ErrInvalidFormat):
+					t.Errorf("frame %d[:0]: %s = %v, want ErrInvalidFormat", i, name, err)
+				case n > 0 && !truncated(err):
+					t.Errorf("frame %d[:%d]: %s = %v, want an out of bounds error", i, n, name, err)
+				case de.Offset > n:
+					t.Errorf("frame %d[:%d]: %s offset %d past the input", i, n, name, de.Offset)
+				}
+			}
+		}
+	}
+}
+
+func TestMapKeyPaths(t *testing.T) {
+	frame := testFrames()[1]
+	for _, key := range []string{"atom", "binary", "7", "-123456789012345678901234567890", "chars", "[1,\"two\"]"} {
+		if _, err := Get(frame, key); err != nil {
+			t.Errorf("Get(%q): %v", key, err)
+		}
+	}
+
+	out, err := NewDecoder().Unpack(frame)
+	var m map[string]int
+	if err == nil {
+		err = json.Unmarshal(out, &m)
+	}
+	if err != nil || len(m) != 6 {
+		t.Errorf("Unpack = %s, %v", out, err)
+	}
+}
+
+func TestDecoderLimits(t *testing.T) {
+	e := NewEncoder()
+	list := make([]any, 10)
+	for i := range list {
+		list[i] = "abc"
+	}
+	listJSON, err := NewDecoder().Unpack(e.Pack(list))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tt := range []struct {
+		name  string
+		frame []byte
+		path  []string
+		set   func(d *Decoder, n int)
+		at    int      // the smallest limit frame passes
+		want  error    // the error one below it
+		only  []string // the decoders subject to the limit, nil for all
+	}{
+		{
+			name:  "depth",
+			frame: e.Pack([]any{[]any{[]any{[]any{1}}}}),
+			path:  []string{"0"},
+			set:   func(d *Decoder, n int) { d.MaxDepth = n },
+			at:    4,
+			want:  ErrMaxDepth,
+			only:  []string{"Unpack", "UnpackValue", "Skip", "Validate", "ParseTerm", "Get", "StreamDecoder"},
+		},
+		{
+			name:  "elements",
+			frame: e.Pack(list),
+			path:  []string{"0"},
+			set:   func(d *Decoder, n int) { d.MaxElements = n },
+			at:    10,
+			want:  ErrMaxElements,
+		},
+		{
+			name:  "binary",
+			frame: e.Pack(map[string]any{"k": strings.Repeat("b", 100)}),
+			path:  []string{"k"},
+			set:   func(d *Decoder, n int) { d.MaxBinarySize = n },
+			at:    100,
+			want:  ErrMaxBinarySize,
+		},
+		{
+			name:  "atom",
+			frame: e.Pack(Atom(strings.Repeat("a", 100))),
+			set:   func(d *Decoder, n int) { d.MaxBinarySize = n },
+			at:    100,
+			want:  ErrMaxBinarySize,
+		},
+		{
+			name:  "bignum",
+			frame: e.Pack(new(big.Int).Lsh(big.NewInt(1), 799)),
+			set:   func(d *Decoder, n int) { d.MaxBinarySize = n },
+			at:    100,
+			want:  ErrMaxBinarySize,
+		},
+		{
+			name:  "output",
+			frame: e.Pack(list),
+			path:  []string{"0"},
+			set:   func(d *Decoder, n int) { d.MaxOutputSize = n },
+			at:    len(listJSON),
+			want:  ErrMaxOutputSize,
+			only:  []string{"Unpack", "StreamDecoder"},
+		},
+	} {
+		d := NewDecoder()
+		tt.set(d, tt.at)
+		for name, decode := range decoders(d, tt.frame, tt.path...) {
+			if err := decode(); err != nil {
+				t.Errorf("%s at the limit: %s = %v", tt.name, name, err)
+			}
+		}
+
+		tt.set(d, tt.at-1)
+		for name, decode := range decoders(d, tt.frame, tt.path...) {
+			want := tt.want
+			if tt.only != nil && !slices.Contains(tt.only, name) {
+				want = nil
+			}
+			if err := decode(); !errors.Is(err, want) {
+				t.Errorf("%s over the limit: %s = %v, want %v", tt.name, name, err, want)
+			}
+		}
+	}
+}
+
+func TestDecodeErrorPath(t *testing.T) {
+	marker := []byte{SMALL_ATOM_UTF8_EXT, 7, 'c', 'o', 'r', 'r', 'u', 'p', 't'}
+	member := func(id int) map[string]any { return map[string]any{"id": id, "user": id} }
+
+	for _, tt := range []struct {
+		value any
+		get   []string
+		want  string
+	}{
+		{
+			value: map[string]any{"op": 0, "d": map[string]any{
+				"members": []any{member(0), member(1), member(2), map[string]any{"id": 3, "user": Atom("corrupt")}},
+			}},
+			get:  []string{"d", "members"},
+			want: "/d/members/3/user",
+		},
+		{
+			value: map[string]any{"a/b": map[string]any{"c~d": Atom("corrupt")}},
+			get:   []string{"a/b"},
+			want:  "/a~1b/c~0d",
+		},
+		{
+			value: Tuple{1, []any{2, Atom("corrupt")}},
+			get:   []string{"1"},
+			want:  "/1/1",
+		},
+	} {
+		frame := NewEncoder().Pack(tt.value)
+		at := bytes.Index(frame, marker)
+		frame[at] = 0xff
+
+		for name, decode := range decoders(NewDecoder(), frame, tt.get...) {
+			switch name {
+			case "StreamDecoder", "Reader", "ParseTerm":
+				// They report no path, or number map entries rather
+				// than naming their keys.
+				continue
+			}
+			var de *DecodeError
+			err := decode()
+			if !errors.As(err, &de) || !errors.Is(err, ErrUnsupportedTag) {
+				t.Errorf("%s: %s = %v, want an ErrUnsupportedTag DecodeError", tt.want, name, err)
+				continue
+			}
+			if de.Path != tt.want || de.Offset != at || de.Tag != 0xff {
+				t.Errorf("%s: %s error at %s, offset %d, tag %d; want offset %d", tt.want, name, de.Path, de.Offset, de.Tag, at)
+			}
+		}
+	}
+}
+
+func TestSkipAllocs(t *testing.T) {
+	for i, frame := range testFrames() {
+		if n := testing.AllocsPerRun(100, func() { Validate(frame) }); n != 0 {
+			t.Errorf("frame %d: Validate made %v allocations", i, n)
+		}
+		if n := testing.AllocsPerRun(100, func() { Skip(frame) }); n != 0 {
+			t.Errorf("frame %d: Skip made %v allocations", i, n)
+		}
+	}
+}
+
+func TestGetLimits(t *testing.T) {
+	e := NewEncoder()
+	deep := e.Pack([]any{[]any{[]any{[]any{1}}}})
+	wide := e.Pack(map[string]any{"a": 1, "b": 2, "c": 3})
+
+	for _, tt := range []struct {
+		frame []byte
+		path  []string
+		limit func(*Decoder)
+		want  error
+	}{
+		{deep, []string{"0", "0", "0", "0"}, func(d *Decoder) { d.MaxDepth = 3 }, ErrMaxDepth},
+		{deep, []string{"0", "0", "0"}, func(d *Decoder) { d.MaxDepth = 3 }, ErrMaxDepth},
+		{deep, []string{"0", "0", "0", "0"}, func(d *Decoder) { d.MaxDepth = 4 }, nil},
+		{wide, []string{"a"}, func(d *Decoder) { d.MaxElements = 2 }, ErrMaxElements},
+		{wide, []string{"a"}, func(d *Decoder) { d.MaxElements = 3 }, nil},
+	} {
+		d := NewDecoder()
+		tt.limit(d)
+		if _, err := d.Get(tt.frame, tt.path...); !errors.Is(err, tt.want) {
+			t.Errorf("Get(%v) = %v, want %v", tt.path, err, tt.want)
+		}
+	}
+}
+
+func FuzzUnpack(f *testing.F) {
+	for _, frame := range testFrames() {
+		f.Add(frame)
+	}
+	frame, _ := compressedFrame(f)
+	f.Add(frame)
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		out, err := NewDecoder().Unpack(data)
+		if err == nil && !json.Valid(out) {
+			t.Fatalf("Unpack produced invalid JSON %q", out)
+		}
+		if err == nil {
+			if _, serr := Skip(data); serr != nil {
+				t.Fatalf("Unpack succeeded but Skip failed: %v", serr)
+			}
+		}
+
+		NewDecoder().UnpackValue(data)
+		ParseTerm(data)
+		Trace(data)
+		Get(data, "d", "0")
+		if n, err := Skip(data); err == nil && (n < 2 || n > len(data)) {
+			t.Fatalf("Skip = %d for %d bytes", n, len(data))
+		}
+	})
+}
+
+func TestTupleKey(t *testing.T) {
+	d := NewDecoder()
+	d.TupleKey = "$t"
+
+	for _, tt := range []struct {
+		in        any
+		plain, tk string
+	}{
+		{Tuple{}, `[]`, `{"$t":[]}`},
+		{Tuple{Tuple{Int(1)}, []any{Tuple{}}}, `[[1],[[]]]`, `{"$t":[{"$t":[1]},[{"$t":[]}]]}`},
+		{
+			Map{{Key: Tuple{Int(1), Atom("a")}, Value: Tuple{Int(1), Tuple{}}}},
+			`{"[1,\"a\"]":[1,[]]}`,
+			`{"{\"$t\":[1,\"a\"]}":{"$t":[1,{"$t":[]}]}}`,
+		},
+	} {
+		frame := NewEncoder().Pack(tt.in)
+		if out, err := NewDecoder().Unpack(frame); err != nil || string(out) != tt.plain {
+			t.Errorf("Unpack(%v) = %s, %v; want %s", tt.in, out, err, tt.plain)
+		}
+		if out, err := d.Unpack(frame); err != nil || string(out) != tt.tk {
+			t.Errorf("Unpack(%v) with TupleKey = %s, %v; want %s", tt.in, out, err, tt.tk)
+		}
+	}
+}
+
+func TestBigAsNumber(t *testing.T) {
+	d := NewDecoder()
+	d.BigAsNumber = true
+
+	for _, tt := range []struct {
+		frame        []byte
+		quoted, bare string
+		value        any
+	}{
+		// Three bytes, narrow enough to stay a number either way.
+		{[]byte{131, SMALL_BIG_EXT, 3, 1, 1, 2, 3}, `-197121`, `-197121`, int64(-197121)},
+		{[]byte{131, SMALL_BIG_EXT, 5, 0, 0, 0, 0, 0, 1}, `"4294967296"`, `4294967296`, int64(1 << 32)},
+		{[]byte{131, SMALL_BIG_EXT, 8, 1, 0, 0, 0, 0, 0, 0, 0, 128}, `"-9223372036854775808"`, `-9223372036854775808`, int64(-1 << 63)},
+		{
+			[]byte{131, SMALL_BIG_EXT, 8, 0, 255, 255, 255, 255, 255, 255, 255, 255},
+			`"18446744073709551615"`, `18446744073709551615`,
+			new(big.Int).SetUint64(1<<64 - 1),
+		},
+		{
+			[]byte{131, SMALL_BIG_EXT, 9, 1, 0, 0, 0, 0, 0, 0, 0, 0, 1},
+			`"-18446744073709551616"`, `-18446744073709551616`,
+			new(big.Int).Neg(new(big.Int).Lsh(big.NewInt(1), 64)),
+		},
+	} {
+		if out, err := NewDecoder().Unpack(tt.frame); err != nil || string(out) != tt.quoted {
+			t.Errorf("Unpack(% x) = %s, %v; want %s", tt.frame, out, err, tt.quoted)
+		}
+		if out, err := d.Unpack(tt.frame); err != nil || string(out) != tt.bare {
+			t.Errorf("Unpack(% x) with BigAsNumber = %s, %v; want %s", tt.frame, out, err, tt.bare)
+		}
+		v, err := NewDecoder().UnpackValue(tt.frame)
+		if b, ok := tt.value.(*big.Int); ok {
+			if vb, _ := v.(*big.Int); err != nil || vb == nil || vb.Cmp(b) != 0 {
+				t.Errorf("UnpackValue(% x) = %v, %v; want %v", tt.frame, v, err, b)
+			}
+		} else if err != nil || v != tt.value {
+			t.Errorf("UnpackValue(% x) = %#v, %v; want %#v", tt.frame, v, err, tt.value)
+		}
+	}
+}
@@ -2,103 +2,337 @@ package erlpack
 
 import (
 	"encoding/binary"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"math"
+	"math/big"
+	"math/bits"
 	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode/utf8"
 )
 
-type Encoder struct{}
+var (
+	ErrUnsupportedType = errors.New("unsupported etf type")
+	ErrTooLarge        = errors.New("value is too large")
+	ErrInvalidMapKey   = errors.New("map key must be a string")
+	ErrInvalidFloat    = errors.New("float must be finite")
+	ErrInvalidEncoding = errors.New("term does not fit its recorded tag")
+
+	pathEscaper = strings.NewReplacer("~", "~0", "/", "~1")
+
+	encodeFieldCache sync.Map
+
+	jsonMarshalerType = reflect.TypeFor[json.Marshaler]()
+	bigIntType        = reflect.TypeFor[*big.Int]()
+	stringerType      = reflect.TypeFor[fmt.Stringer]()
+)
+
+// EncodeError reports the Go type that could not be encoded and where it
+// sits in the value, as a JSON-pointer-style path.
+type EncodeError struct {
+	Type reflect.Type
+	Path string
+	Err  error
+}
+
+func (e *EncodeError) Error() string {
+	path := e.Path
+	if path == "" {
+		path = "/"
+	}
+	return fmt.Sprintf("%v: %v at %s", e.Err, e.Type, path)
+}
+
+func (e *EncodeError) Unwrap() error {
+	return e.Err
+}
+
+func encodeError(err error, value any) error {
+	return &EncodeError{Type: reflect.TypeOf(value), Err: err}
+}
+
+// withPath prefixes the path of an EncodeError raised while encoding a
+// child of the current container.
+func withPath(err error, key string) error {
+	if ee, ok := err.(*EncodeError); ok {
+		ee.Path = "/" + pathEscaper.Replace(key) + ee.Path
+	}
+	return err
+}
+
+func withIndex(err error, i int) error {
+	return withPath(err, strconv.Itoa(i))
+}
+
+type Encoder struct {
+	// LegacyAtoms emits ASCII atoms with the latin-1 ATOM_EXT and
+	// SMALL_ATOM_EXT tags for peers older than OTP 26.
+	LegacyAtoms bool
+
+	// CompressThreshold, when positive, zlib-compresses terms whose encoded
+	// size exceeds it into a COMPRESSED term, as term_to_binary does with
+	// the compressed option. The compressed form is only used if smaller.
+	CompressThreshold int
+
+	w io.Writer
+}
 
 func NewEncoder() *Encoder {
 	return &Encoder{}
 }
 
-func (*Encoder) AppendByte(b byte) []byte {
-	return append([]byte{}, b)
+func (*Encoder) AppendByte(dst []byte, b byte) []byte {
+	return append(dst, b)
 }
 
-func (*Encoder) AppendUint32(val uint32) []byte {
-	buf := make([]byte, 4)
-	binary.BigEndian.PutUint32(buf, val)
-	return buf
+func (*Encoder) AppendUint32(dst []byte, val uint32) []byte {
+	return binary.BigEndian.AppendUint32(dst, val)
 }
 
-func (*Encoder) AppendUint16(val uint16) []byte {
-	buf := make([]byte, 2)
-	binary.BigEndian.PutUint16(buf, val)
-	return buf
+func (*Encoder) AppendUint16(dst []byte, val uint16) []byte {
+	return binary.BigEndian.AppendUint16(dst, val)
 }
 
-func (e *Encoder) AppendBinary(s string) []byte {
-	result := e.AppendByte(BINARY_EXT)
-	result = append(result, e.AppendUint32(uint32(len(s)))...)
-	result = append(result, []byte(s)...)
-	return result
+func (e *Encoder) AppendBinary(dst []byte, s string) []byte {
+	dst = append(dst, BINARY_EXT)
+	dst = e.AppendUint32(dst, uint32(len(s)))
+	return append(dst, s...)
 }
 
-func (*Encoder) AppendFloat64(f float64) []byte {
-	buf := make([]byte, 8)
-	binary.BigEndian.PutUint64(buf, math.Float64bits(f))
-	return buf
+func (*Encoder) AppendFloat64(dst []byte, f float64) []byte {
+	return binary.BigEndian.AppendUint64(dst, math.Float64bits(f))
 }
 
-func (e *Encoder) AppendInt(v int64) []byte {
+func (e *Encoder) AppendInt(dst []byte, v int64) []byte {
 	if v >= 0 && v <= 255 {
-		result := e.AppendByte(SMALL_INTEGER_EXT)
-		result = append(result, byte(v))
-		return result
+		return append(dst, SMALL_INTEGER_EXT, byte(v))
+	} else if v >= math.MinInt32 && v <= math.MaxInt32 {
+		dst = append(dst, INTEGER_EXT)
+		return e.AppendInt32(dst, int32(v))
+	} else if v < 0 {
+		return e.appendSmallBig(dst, true, uint64(-v))
+	} else {
+		return e.appendSmallBig(dst, false, uint64(v))
+	}
+}
+
+func (e *Encoder) AppendUint(dst []byte, v uint64) []byte {
+	if v <= math.MaxInt32 {
+		return e.AppendInt(dst, int64(v))
+	}
+	return e.appendSmallBig(dst, false, v)
+}
+
+func (*Encoder) appendSmallBig(dst []byte, neg bool, v uint64) []byte {
+	var sign byte
+	if neg {
+		sign = 1
+	}
+
+	dst = append(dst, SMALL_BIG_EXT, byte((bits.Len64(v)+7)/8), sign)
+	for ; v > 0; v >>= 8 {
+		dst = append(dst, byte(v))
+	}
+	return dst
+}
+
+func (e *Encoder) AppendBig(dst []byte, b *big.Int) []byte {
+	if b.IsInt64() {
+		return e.AppendInt(dst, b.Int64())
+	}
+
+	mag := b.Bytes()
+	length := len(mag)
+
+	if length < 256 {
+		dst = append(dst, SMALL_BIG_EXT, byte(length))
 	} else {
-		result := e.AppendByte(INTEGER_EXT)
-		result = append(result, e.AppendInt32(int32(v))...)
-		return result
+		dst = append(dst, LARGE_BIG_EXT)
+		dst = e.AppendUint32(dst, uint32(length))
 	}
+
+	if b.Sign() < 0 {
+		dst = append(dst, 1)
+	} else {
+		dst = append(dst, 0)
+	}
+
+	for i := length - 1; i >= 0; i-- {
+		dst = append(dst, mag[i])
+	}
+	return dst
+}
+
+func (*Encoder) AppendInt32(dst []byte, v int32) []byte {
+	return binary.BigEndian.AppendUint32(dst, uint32(v))
 }
 
-func (*Encoder) AppendInt32(v int32) []byte {
-	buf := make([]byte, 4)
-	binary.BigEndian.PutUint32(buf, uint32(v))
-	return buf
+func (*Encoder) AppendInt64(dst []byte, v int64) []byte {
+	return binary.BigEndian.AppendUint64(dst, uint64(v))
 }
 
-func (*Encoder) AppendInt64(v int64) []byte {
-	buf := make([]byte, 8)
-	binary.BigEndian.PutUint64(buf, uint64(v))
-	return buf
+// AppendMap appends m as a map with binary keys. It panics if a value
+// cannot be encoded.
+func (e *Encoder) AppendMap(dst []byte, m map[string]any) []byte {
+	dst, err := e.appendMap(dst, m)
+	if err != nil {
+		panic(err)
+	}
+	return dst
 }
 
-func (e *Encoder) AppendMap(m map[string]any) []byte {
+func (e *Encoder) appendMap(dst []byte, m map[string]any) ([]byte, error) {
 	length := len(m)
 
 	if length > math.MaxUint32-1 {
-		panic("Dictionary has too many properties")
+		return nil, encodeError(ErrTooLarge, m)
 	}
 
-	result := e.AppendByte(MAP_EXT)
-	result = append(result, e.AppendUint32(uint32(length))...)
+	dst = append(dst, MAP_EXT)
+	dst = e.AppendUint32(dst, uint32(length))
 	for key, val := range m {
-		result = append(result, e.AppendBinary(key)...)
-		result = append(result, e.rawPack(val)...)
+		var err error
+		dst = e.AppendBinary(dst, key)
+		if dst, err = e.rawPack(dst, val); err != nil {
+			return nil, withPath(err, key)
+		}
+		if dst, err = e.spill(dst); err != nil {
+			return nil, err
+		}
 	}
-	return result
+	return dst, nil
 }
 
-func (*Encoder) AppendNil() []byte {
-	return []byte{SMALL_ATOM_EXT, 3, 'n', 'i', 'l'}
+// AppendAtom appends a as an atom. It panics if a is longer than 65535
+// bytes.
+func (e *Encoder) AppendAtom(dst []byte, a Atom) []byte {
+	dst, err := e.appendAtom(dst, a)
+	if err != nil {
+		panic(err)
+	}
+	return dst
 }
 
-func (*Encoder) AppendBool(v bool) []byte {
-	if v {
-		return []byte{SMALL_ATOM_EXT, 4, 't', 'r', 'u', 'e'}
+func (e *Encoder) appendAtom(dst []byte, a Atom) ([]byte, error) {
+	length := len(a)
+
+	if length > math.MaxUint16 {
+		return nil, encodeError(ErrTooLarge, a)
+	}
+
+	var small, large byte = SMALL_ATOM_UTF8_EXT, ATOM_UTF8_EXT
+	if e.LegacyAtoms && isASCII(a) {
+		small, large = SMALL_ATOM_EXT, ATOM_EXT
 	}
 
-	return []byte{SMALL_ATOM_EXT, 5, 'f', 'a', 'l', 's', 'e'}
+	if length < 256 {
+		dst = append(dst, small, byte(length))
+	} else {
+		dst = append(dst, large)
+		dst = e.AppendUint16(dst, uint16(length))
+	}
+	return append(dst, a...), nil
 }
 
-func (*Encoder) convertMap(x any) (map[string]any, bool) {
-	v := reflect.ValueOf(x)
-	if v.Kind() != reflect.Map {
-		return nil, false
+// isFinite reports whether f can be represented in ETF, which has no NaN
+// or infinity.
+func isFinite(f float64) bool {
+	return !math.IsNaN(f) && !math.IsInf(f, 0)
+}
+
+func isASCII(a Atom) bool {
+	for i := range len(a) {
+		if a[i] >= utf8.RuneSelf {
+			return false
+		}
+	}
+	return true
+}
+
+// AppendTuple appends t as a tuple. It panics if an element cannot be
+// encoded.
+func (e *Encoder) AppendTuple(dst []byte, t Tuple) []byte {
+	dst, err := e.appendTuple(dst, t)
+	if err != nil {
+		panic(err)
+	}
+	return dst
+}
+
+func (e *Encoder) appendTuple(dst []byte, t Tuple) ([]byte, error) {
+	length := len(t)
+
+	if length > math.MaxUint32-1 {
+		return nil, encodeError(ErrTooLarge, t)
+	}
+
+	if length < 256 {
+		dst = append(dst, SMALL_TUPLE_EXT, byte(length))
+	} else {
+		dst = append(dst, LARGE_TUPLE_EXT)
+		dst = e.AppendUint32(dst, uint32(length))
 	}
+	return e.appendElems(dst, t)
+}
+
+func (e *Encoder) appendElems(dst []byte, t Tuple) ([]byte, error) {
+	for i := range t {
+		var err error
+		if dst, err = e.rawPack(dst, t[i]); err != nil {
+			return nil, withIndex(err, i)
+		}
+		if dst, err = e.spill(dst); err != nil {
+			return nil, err
+		}
+	}
+	return dst, nil
+}
+
+func (e *Encoder) appendList(dst []byte, v []any) ([]byte, error) {
+	if len(v) > math.MaxUint32-1 {
+		return nil, encodeError(ErrTooLarge, v)
+	}
+
+	dst = append(dst, LIST_EXT)
+	dst = e.AppendUint32(dst, uint32(len(v)))
+	for i := range v {
+		var err error
+		if dst, err = e.rawPack(dst, v[i]); err != nil {
+			return nil, withIndex(err, i)
+		}
+		if dst, err = e.spill(dst); err != nil {
+			return nil, err
+		}
+	}
+	return append(dst, NIL_EXT), nil
+}
+
+func (e *Encoder) AppendNil(dst []byte) []byte {
+	if e.LegacyAtoms {
+		return append(dst, SMALL_ATOM_EXT, 3, 'n', 'i', 'l')
+	}
+	return append(dst, SMALL_ATOM_UTF8_EXT, 3, 'n', 'i', 'l')
+}
+
+func (e *Encoder) AppendBool(dst []byte, v bool) []byte {
+	tag := byte(SMALL_ATOM_UTF8_EXT)
+	if e.LegacyAtoms {
+		tag = SMALL_ATOM_EXT
+	}
+
+	if v {
+		return append(dst, tag, 4, 't', 'r', 'u', 'e')
+	}
+
+	return append(dst, tag, 5, 'f', 'a', 'l', 's', 'e')
+}
+
+func (*Encoder) convertMap(v reflect.Value) (map[string]any, error) {
 	out := make(map[string]any, v.Len())
 	for _, key := range v.MapKeys() {
 		var keyStr string
@@ -108,114 +342,505 @@ func (*Encoder) convertMap(x any) (map[string]any, bool) {
 		} else if s, ok := key.Interface().(fmt.Stringer); ok {
 			keyStr = s.String()
 		} else {
-			panic(fmt.Sprintf("expected string, got %s", key.Type()))
+			return nil, encodeError(ErrInvalidMapKey, key.Interface())
 		}
 
 		out[keyStr] = v.MapIndex(key).Interface()
 	}
-	return out, true
+	return out, nil
+}
+
+func Marshal(v any) ([]byte, error) {
+	return NewEncoder().PackE(v)
 }
 
+// Pack returns the encoding of value with the version byte. It panics if
+// value cannot be encoded; use PackE or Marshal to get the error instead.
 func (e *Encoder) Pack(value any) []byte {
-	return append([]byte{FORMAT_VERSION}, e.rawPack(value)...)
+	return e.AppendPack(nil, value)
 }
 
-func (e *Encoder) rawPack(value any) []byte {
-	var result []byte
+func (e *Encoder) PackE(value any) ([]byte, error) {
+	return e.appendPack(nil, value)
+}
+
+// AppendPack appends the version byte and the encoded value to dst. When
+// dst has enough capacity no allocation takes place for maps, slices,
+// structs and scalars. It panics if value cannot be encoded, as Pack does.
+func (e *Encoder) AppendPack(dst []byte, value any) []byte {
+	dst, err := e.appendPack(dst, value)
+	if err != nil {
+		panic(err)
+	}
+	return dst
+}
+
+func (e *Encoder) appendPack(dst []byte, value any) ([]byte, error) {
+	dst = append(dst, FORMAT_VERSION)
+	start := len(dst)
+
+	dst, err := e.rawPack(dst, value)
+	if err != nil {
+		return nil, err
+	}
 
+	if e.CompressThreshold > 0 && len(dst)-start > e.CompressThreshold {
+		return e.compress(dst, start)
+	}
+	return dst, nil
+}
+
+func (e *Encoder) rawPack(dst []byte, value any) ([]byte, error) {
 	switch v := value.(type) {
 	case int:
-		result = append(result, e.AppendInt(int64(v))...)
+		return e.AppendInt(dst, int64(v)), nil
 	case *int:
 		if v == nil {
-			result = append(result, e.AppendNil()...)
-		} else {
-			result = append(result, e.AppendInt(int64(*v))...)
+			return e.AppendNil(dst), nil
 		}
+		return e.AppendInt(dst, int64(*v)), nil
+	case int8:
+		return e.AppendInt(dst, int64(v)), nil
+	case int16:
+		return e.AppendInt(dst, int64(v)), nil
 	case int32:
-		result = append(result, e.AppendInt(int64(v))...)
+		return e.AppendInt(dst, int64(v)), nil
 	case int64:
-		result = append(result, e.AppendInt(v)...)
+		return e.AppendInt(dst, v), nil
+	case uint:
+		return e.AppendUint(dst, uint64(v)), nil
+	case uint8:
+		return e.AppendUint(dst, uint64(v)), nil
+	case uint16:
+		return e.AppendUint(dst, uint64(v)), nil
+	case uint32:
+		return e.AppendUint(dst, uint64(v)), nil
+	case uint64:
+		return e.AppendUint(dst, v), nil
+	case uintptr:
+		return e.AppendUint(dst, uint64(v)), nil
+	case *big.Int:
+		if v == nil {
+			return e.AppendNil(dst), nil
+		}
+		return e.AppendBig(dst, v), nil
 	case float32:
-		result = append(result, e.AppendByte(NEW_FLOAT_EXT)...)
-		result = append(result, e.AppendFloat64(float64(v))...)
+		if !isFinite(float64(v)) {
+			return nil, encodeError(ErrInvalidFloat, v)
+		}
+		dst = append(dst, NEW_FLOAT_EXT)
+		return e.AppendFloat64(dst, float64(v)), nil
 	case float64:
-		result = append(result, e.AppendByte(NEW_FLOAT_EXT)...)
-		result = append(result, e.AppendFloat64(v)...)
+		if !isFinite(v) {
+			return nil, encodeError(ErrInvalidFloat, v)
+		}
+		dst = append(dst, NEW_FLOAT_EXT)
+		return e.AppendFloat64(dst, v), nil
 	case *string:
 		if v == nil {
-			result = append(result, e.AppendNil()...)
-		} else {
-			result = append(result, e.AppendBinary(*v)...)
+			return e.AppendNil(dst), nil
 		}
+		return e.AppendBinary(dst, *v), nil
 	case string:
-		result = append(result, e.AppendBinary(v)...)
+		return e.AppendBinary(dst, v), nil
 	case bool:
-		result = append(result, e.AppendBool(v)...)
+		return e.AppendBool(dst, v), nil
+	case Atom:
+		return e.appendAtom(dst, v)
+	case Tuple:
+		return e.appendTuple(dst, v)
 	case nil:
-		result = append(result, e.AppendNil()...)
+		return e.AppendNil(dst), nil
 	case []any:
-		result = append(result, e.AppendByte(LIST_EXT)...)
-		result = append(result, e.AppendUint32(uint32(len(v)))...)
-		for i := range v {
-			result = append(result, e.rawPack(v[i])...)
-		}
-		result = append(result, e.AppendByte(NIL_EXT)...)
+		return e.appendList(dst, v)
 	case map[string]any:
-		result = append(result, e.AppendMap(v)...)
+		return e.appendMap(dst, v)
+	case Term:
+		return v.appendTerm(e, dst)
 	default:
-		if stringer, ok := value.(fmt.Stringer); ok {
-			result = append(result, e.rawPack(stringer.String())...)
-			return result
-		}
-
 		t := reflect.TypeOf(v)
 		val := reflect.ValueOf(v)
 
+		// A nil pointer is nil even when its type has a String method.
+		if stringer, ok := value.(fmt.Stringer); ok && !isNilValue(val) {
+			return e.AppendBinary(dst, stringer.String()), nil
+		}
+
 		for t.Kind() == reflect.Pointer {
 			if val.IsNil() {
-				result = append(result, e.AppendNil()...)
-				return result
+				return e.AppendNil(dst), nil
 			}
 
 			t = t.Elem()
 			val = val.Elem()
 		}
 
-		kind := t.Kind()
-
-		if kind == reflect.Map {
-			v, ok := e.convertMap(v)
-			if ok {
-				return e.AppendMap(v)
+		switch t.Kind() {
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			return e.AppendInt(dst, val.Int()), nil
+		case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+			return e.AppendUint(dst, val.Uint()), nil
+		case reflect.Float32, reflect.Float64:
+			if !isFinite(val.Float()) {
+				return nil, encodeError(ErrInvalidFloat, v)
 			}
-		}
-
-		switch kind {
+			dst = append(dst, NEW_FLOAT_EXT)
+			return e.AppendFloat64(dst, val.Float()), nil
+		case reflect.Map:
+			m, err := e.convertMap(val)
+			if err != nil {
+				return nil, err
+			}
+			return e.appendMap(dst, m)
 		case reflect.Struct:
-			var data = NewStruct(v).Map()
-			result = append(result, e.rawPack(data)...)
+			return e.appendStruct(dst, val)
 		case reflect.Slice, reflect.Array:
 			length := val.Len()
 
 			if length == 0 {
-				result = append(result, e.AppendByte(NIL_EXT)...)
-				return result
+				return append(dst, NIL_EXT), nil
 			} else if length > math.MaxUint32-1 {
-				panic("List is too large")
+				return nil, encodeError(ErrTooLarge, v)
+			}
+
+			dst = append(dst, LIST_EXT)
+			dst = e.AppendUint32(dst, uint32(length))
+			for i := range length {
+				var err error
+				if dst, err = e.appendReflect(dst, val.Index(i)); err != nil {
+					return nil, withIndex(err, i)
+				}
+				if dst, err = e.spill(dst); err != nil {
+					return nil, err
+				}
+			}
+			return append(dst, NIL_EXT), nil
+		default:
+			return nil, encodeError(ErrUnsupportedType, v)
+		}
+	}
+}
+
+// appendReflect encodes val as rawPack encodes val.Interface(), without
+// boxing values of the predeclared scalar types.
+func (e *Encoder) appendReflect(dst []byte, val reflect.Value) ([]byte, error) {
+	if t := val.Type(); t.PkgPath() == "" && t.Name() != "" {
+		switch t.Kind() {
+		case reflect.String:
+			return e.AppendBinary(dst, val.String()), nil
+		case reflect.Bool:
+			return e.AppendBool(dst, val.Bool()), nil
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			return e.AppendInt(dst, val.Int()), nil
+		case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+			return e.AppendUint(dst, val.Uint()), nil
+		case reflect.Float32, reflect.Float64:
+			if !isFinite(val.Float()) {
+				return nil, encodeError(ErrInvalidFloat, val.Interface())
+			}
+			dst = append(dst, NEW_FLOAT_EXT)
+			return e.AppendFloat64(dst, val.Float()), nil
+		}
+	}
+	return e.rawPack(dst, val.Interface())
+}
+
+// encodeField is a struct field as Struct.FillMap lays it out: a map entry
+// called name or, for embedded structs, the inline fields merged into the
+// parent map.
+type encodeField struct {
+	name       string
+	index      int
+	omitEmpty  bool
+	omitZero   bool
+	omitNested bool
+	asString   bool
+	flatten    bool
+	inline     []encodeField
+}
+
+// encodeFields is the cached layout of a struct type. direct is false when
+// the map Struct.Map builds depends on more than the field values, as with
+// colliding names or json.Marshaler fields; such structs are still encoded
+// through Struct.Map.
+type encodeFields struct {
+	fields []encodeField
+	direct bool
+}
+
+func cachedEncodeFields(t reflect.Type) *encodeFields {
+	if f, ok := encodeFieldCache.Load(t); ok {
+		return f.(*encodeFields)
+	}
+	fields, ok := collectEncodeFields(t, map[string]bool{})
+	f, _ := encodeFieldCache.LoadOrStore(t, &encodeFields{fields: fields, direct: ok})
+	return f.(*encodeFields)
+}
+
+// collectEncodeFields mirrors Struct.FillMap so that encoding a struct
+// directly produces the same map as encoding Struct.Map.
+func collectEncodeFields(t reflect.Type, names map[string]bool) ([]encodeField, bool) {
+	var fields []encodeField
+
+	for i := range t.NumField() {
+		field := t.Field(i)
+		if field.PkgPath != "" {
+			continue
+		}
+
+		tag := field.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+
+		tagName, tagOpts := parseTag(tag)
+		if marshalsJSON(field.Type) || tagOpts.Has("flatten") {
+			return nil, false
+		}
+
+		if field.Anonymous && field.Type.Kind() == reflect.Struct && len(tagOpts) == 0 {
+			inline, ok := collectEncodeFields(field.Type, names)
+			if !ok {
+				return nil, false
+			}
+			fields = append(fields, encodeField{index: i, flatten: true, inline: inline})
+			continue
+		}
+
+		name := field.Name
+		if tagName != "" {
+			name = tagName
+		}
+		if names[name] {
+			return nil, false
+		}
+		names[name] = true
+
+		fields = append(fields, encodeField{
+			name:       name,
+			index:      i,
+			omitEmpty:  tagOpts.Has("omitempty"),
+			omitZero:   tagOpts.Has("omitzero"),
+			omitNested: tagOpts.Has("omitnested"),
+			asString:   tagOpts.Has("string"),
+		})
+	}
+
+	return fields, true
+}
+
+// omit reports whether Struct.FillMap leaves the field out of the map.
+func (f *encodeField) omit(v reflect.Value) bool {
+	switch {
+	// IsZero agrees with the reflect.DeepEqual test against the zero value
+	// that omitempty makes in FillMap, negative zero included.
+	case f.omitEmpty && v.IsZero(), f.omitZero && isZeroValue(v):
+		return true
+	}
+	return false
+}
+
+// marshalsJSON reports whether Struct.FillMap replaces values of type t
+// with their JSON form. *big.Int is encoded as a bignum instead, since its
+// JSON number would come back as a float64.
+func marshalsJSON(t reflect.Type) bool {
+	return t != bigIntType && t.Implements(jsonMarshalerType)
+}
+
+// countFields returns the number of map entries the fields of v produce.
+// It reports false if an interface field holds a json.Marshaler, whose
+// JSON form only Struct.Map reproduces.
+func countFields(v reflect.Value, fields []encodeField) (int, bool) {
+	n := 0
+	for i := range fields {
+		f := &fields[i]
+		fv := v.Field(f.index)
+		if f.flatten {
+			c, ok := countFields(fv, f.inline)
+			if !ok {
+				return 0, false
 			}
+			n += c
+			continue
+		}
+
+		if fv.Kind() == reflect.Interface && !fv.IsNil() && marshalsJSON(fv.Elem().Type()) {
+			return 0, false
+		}
+		if !f.omit(fv) {
+			n++
+		}
+	}
+	return n, true
+}
+
+// structFields returns the layout of the struct v and how many map entries
+// it produces, or false if v must be encoded through Struct.Map.
+func structFields(v reflect.Value) ([]encodeField, int, bool) {
+	layout := cachedEncodeFields(v.Type())
+	if !layout.direct {
+		return nil, 0, false
+	}
+	n, ok := countFields(v, layout.fields)
+	return layout.fields, n, ok
+}
+
+// appendStruct encodes the struct v as the map Struct.Map builds for it,
+// writing the fields straight into dst.
+func (e *Encoder) appendStruct(dst []byte, v reflect.Value) ([]byte, error) {
+	fields, n, ok := structFields(v)
+	if !ok {
+		return e.appendMap(dst, NewStruct(v.Interface()).Map())
+	}
+
+	dst = append(dst, MAP_EXT)
+	dst = e.AppendUint32(dst, uint32(n))
+	return e.appendFields(dst, v, fields)
+}
+
+func (e *Encoder) appendFields(dst []byte, v reflect.Value, fields []encodeField) ([]byte, error) {
+	for i := range fields {
+		f := &fields[i]
+		fv := v.Field(f.index)
 
-			result = append(result, e.AppendByte(LIST_EXT)...)
-			result = append(result, e.AppendUint32(uint32(val.Len()))...)
-			for i := range val.Len() {
-				item := val.Index(i).Interface()
-				result = append(result, e.rawPack(item)...)
+		var err error
+		if f.flatten {
+			if dst, err = e.appendFields(dst, fv, f.inline); err != nil {
+				return nil, err
 			}
-			result = append(result, e.AppendByte(NIL_EXT)...)
+			continue
+		}
+		if f.omit(fv) {
+			continue
+		}
+
+		var quoted string
+		asString := false
+		if f.asString {
+			quoted, asString = quoteField(fv)
+		}
+
+		dst = e.AppendBinary(dst, f.name)
+		switch {
+		case asString:
+			dst = e.AppendBinary(dst, quoted)
+		case f.omitNested:
+			dst, err = e.appendReflect(dst, fv)
+		case isNilValue(fv):
+			dst = e.AppendNil(dst)
 		default:
-			panic("Unsupported etf type: " + fmt.Sprintf("%T", v))
+			dst, err = e.appendNested(dst, fv)
+		}
+		if err != nil {
+			return nil, withPath(err, f.name)
+		}
+		if dst, err = e.spill(dst); err != nil {
+			return nil, err
 		}
 	}
+	return dst, nil
+}
+
+// appendNested encodes a field value the way Struct.FillMap nests it:
+// structs, and maps and slices of structs, become maps and lists of their
+// fields, and anything else is encoded as it is.
+func (e *Encoder) appendNested(dst []byte, val reflect.Value) ([]byte, error) {
+	v := val
+	if v.Kind() == reflect.Interface {
+		v = v.Elem()
+	}
+	if v.Kind() == reflect.Pointer {
+		v = v.Elem()
+	}
 
-	return result
+	t := val.Type()
+	switch v.Kind() {
+	case reflect.Struct:
+		fields, n, ok := structFields(v)
+		if !ok {
+			if m := NewStruct(val.Interface()).Map(); len(m) > 0 {
+				return e.appendMap(dst, m)
+			}
+			return e.rawPack(dst, val.Interface())
+		}
+		// Struct.FillMap keeps a struct without entries as it is rather
+		// than as an empty map.
+		if n == 0 {
+			return e.rawPack(dst, val.Interface())
+		}
+		dst = append(dst, MAP_EXT)
+		dst = e.AppendUint32(dst, uint32(n))
+		return e.appendFields(dst, v, fields)
+	case reflect.Map:
+		if t.Kind() == reflect.Map && nestsStructs(t.Elem()) {
+			return e.appendStructMap(dst, val)
+		}
+	case reflect.Slice, reflect.Array:
+		if (t.Kind() == reflect.Slice || t.Kind() == reflect.Array) && isStructType(t.Elem()) {
+			return e.appendStructList(dst, val)
+		}
+	}
+	return e.appendReflect(dst, val)
+}
+
+func isStructType(t reflect.Type) bool {
+	return t.Kind() == reflect.Struct || t.Kind() == reflect.Pointer && t.Elem().Kind() == reflect.Struct
+}
+
+// nestsStructs reports whether Struct.FillMap rebuilds a map with elements
+// of type t as a map of nested field maps.
+func nestsStructs(t reflect.Type) bool {
+	if t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	return t.Kind() == reflect.Struct || t.Kind() == reflect.Slice && t.Elem().Kind() == reflect.Struct
+}
+
+func (e *Encoder) appendStructMap(dst []byte, val reflect.Value) ([]byte, error) {
+	// Other key kinds are named after their reflect.Value text, which can
+	// collide, so leave those to the map Struct builds.
+	if val.Type().Key().Kind() != reflect.String {
+		s := &Struct{TagName: "json", Flattern: true}
+		return e.rawPack(dst, s.nested(val))
+	}
+
+	if val.Len() > math.MaxUint32-1 {
+		return nil, encodeError(ErrTooLarge, val.Interface())
+	}
+
+	dst = append(dst, MAP_EXT)
+	dst = e.AppendUint32(dst, uint32(val.Len()))
+	iter := val.MapRange()
+	for iter.Next() {
+		var err error
+		key := iter.Key().String()
+		dst = e.AppendBinary(dst, key)
+		if dst, err = e.appendNested(dst, iter.Value()); err != nil {
+			return nil, withPath(err, key)
+		}
+		if dst, err = e.spill(dst); err != nil {
+			return nil, err
+		}
+	}
+	return dst, nil
+}
+
+func (e *Encoder) appendStructList(dst []byte, val reflect.Value) ([]byte, error) {
+	length := val.Len()
+	if length > math.MaxUint32-1 {
+		return nil, encodeError(ErrTooLarge, val.Interface())
+	}
+
+	dst = append(dst, LIST_EXT)
+	dst = e.AppendUint32(dst, uint32(length))
+	for i := range length {
+		var err error
+		if dst, err = e.appendNested(dst, val.Index(i)); err != nil {
+			return nil, withIndex(err, i)
+		}
+		if dst, err = e.spill(dst); err != nil {
+			return nil, err
+		}
+	}
+	return append(dst, NIL_EXT), nil
 }
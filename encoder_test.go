@@ -0,0 +1,335 @@
+package erlpack
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAtomRoundTrip(t *testing.T) {
+	long := Atom(strings.Repeat("a", 300))
+
+	tests := []struct {
+		atom   Atom
+		legacy bool
+		tag    byte
+	}{
+		{"ok", false, SMALL_ATOM_UTF8_EXT},
+		{"héllo", false, SMALL_ATOM_UTF8_EXT},
+		{long, false, ATOM_UTF8_EXT},
+		{"ok", true, SMALL_ATOM_EXT},
+		{"héllo", true, SMALL_ATOM_UTF8_EXT},
+		{long, true, ATOM_EXT},
+	}
+
+	for _, tt := range tests {
+		e := NewEncoder()
+		e.LegacyAtoms = tt.legacy
+		frame := e.Pack(tt.atom)
+		if frame[1] != tt.tag {
+			t.Errorf("Pack(%.10q) legacy=%v: tag %d, want %d", tt.atom, tt.legacy, frame[1], tt.tag)
+		}
+
+		out, err := NewDecoder().Unpack(frame)
+		if want := `"` + string(tt.atom) + `"`; err != nil || string(out) != want {
+			t.Errorf("Unpack(%.10q) legacy=%v = %.20s, %v", tt.atom, tt.legacy, out, err)
+		}
+
+		v, err := NewDecoder().UnpackValue(frame)
+		if err != nil || v != tt.atom {
+			t.Errorf("UnpackValue(%.10q) legacy=%v = %.20v, %v", tt.atom, tt.legacy, v, err)
+		}
+
+		if n, err := Skip(frame); err != nil || n != len(frame) {
+			t.Errorf("Skip(%.10q) legacy=%v = %d, %v; want %d", tt.atom, tt.legacy, n, err, len(frame))
+		}
+
+		got, err := NewStreamDecoder(bytes.NewReader(frame)).DecodeValue()
+		if err != nil || got != tt.atom {
+			t.Errorf("StreamDecoder(%.10q) legacy=%v = %.20v, %v", tt.atom, tt.legacy, got, err)
+		}
+
+		keyed := e.Pack(Map{{Key: tt.atom, Value: Int(1)}})
+		raw, err := Get(keyed, string(tt.atom))
+		if err != nil || !bytes.Equal(raw, []byte{SMALL_INTEGER_EXT, 1}) {
+			t.Errorf("Get(%.10q) legacy=%v = %v, %v", tt.atom, tt.legacy, raw, err)
+		}
+	}
+}
+
+type gatewayPayload struct {
+	Op int    `json:"op"`
+	D  any    `json:"d"`
+	S  *int   `json:"s,omitempty"`
+	T  string `json:"t,omitempty"`
+}
+
+type identifyProperties struct {
+	OS      string `json:"os"`
+	Browser string `json:"browser"`
+	Device  string `json:"device"`
+}
+
+type identify struct {
+	Token          string             `json:"token"`
+	Properties     identifyProperties `json:"properties"`
+	Compress       bool               `json:"compress,omitempty"`
+	LargeThreshold int                `json:"large_threshold,omitempty"`
+	Shard          []int              `json:"shard,omitempty"`
+	Intents        int                `json:"intents"`
+}
+
+type activity struct {
+	Name string `json:"name"`
+	Type int    `json:"type"`
+	URL  string `json:"url,omitempty"`
+}
+
+type presenceUpdate struct {
+	Since      *int64     `json:"since"`
+	Activities []activity `json:"activities"`
+	Status     string     `json:"status"`
+	AFK        bool       `json:"afk"`
+}
+
+func benchmarkAppendPack(b *testing.B, v any) {
+	e := NewEncoder()
+	dst := e.AppendPack(nil, v)
+	b.SetBytes(int64(len(dst)))
+	b.ReportAllocs()
+
+	for b.Loop() {
+		dst = e.AppendPack(dst[:0], v)
+	}
+}
+
+func BenchmarkAppendPackHeartbeat(b *testing.B) {
+	benchmarkAppendPack(b, gatewayPayload{Op: 1, D: int64(251)})
+}
+
+func BenchmarkAppendPackIdentify(b *testing.B) {
+	benchmarkAppendPack(b, gatewayPayload{Op: 2, D: identify{
+		Token:          "MTIzNDU2Nzg5MDEyMzQ1Njc4.GaBcDe.abcdefghijklmnopqrstuvwxyz0123456789AB",
+		Properties:     identifyProperties{OS: "linux", Browser: "erlpack", Device: "erlpack"},
+		LargeThreshold: 250,
+		Shard:          []int{0, 1},
+		Intents:        3276799,
+	}})
+}
+
+func BenchmarkAppendPackPresenceUpdate(b *testing.B) {
+	benchmarkAppendPack(b, gatewayPayload{Op: 3, D: presenceUpdate{
+		Activities: []activity{{Name: "Erlang", Type: 0}},
+		Status:     "online",
+	}})
+}
+
+type shout string
+
+func (s shout) String() string { return strings.ToUpper(string(s)) }
+
+type rawJSON string
+
+func (r rawJSON) MarshalJSON() ([]byte, error) { return []byte(r), nil }
+
+type embedded struct {
+	ID   int    `json:"id"`
+	Note string `json:"note,omitempty"`
+}
+
+type emptyStringer struct {
+	Hidden int `json:"hidden,omitempty"`
+}
+
+func (emptyStringer) String() string { return "empty" }
+
+type structFieldsCase struct {
+	embedded
+	Name       string              `json:"name"`
+	Skipped    int                 `json:"-"`
+	Untagged   float64             ``
+	NegZero    float64             `json:"neg_zero,omitempty"`
+	Empty      []int               `json:"empty,omitempty"`
+	Zero       *int                `json:"zero,omitzero"`
+	Raw        map[string]int      `json:"raw,omitnested"`
+	Loud       shout               `json:"loud,string"`
+	Quiet      int                 `json:"quiet,string"`
+	Any        any                 `json:"any"`
+	Ptr        *embedded           `json:"ptr"`
+	Nil        *embedded           `json:"nil"`
+	List       []embedded          `json:"list"`
+	PtrList    []*embedded         `json:"ptr_list"`
+	Keyed      map[string]embedded `json:"keyed"`
+	Blank      emptyStringer       `json:"blank"`
+	Atom       Atom                `json:"atom"`
+	unexported int
+}
+
+type duplicateNames struct {
+	embedded
+	Other int `json:"id"`
+}
+
+type flattened struct {
+	Inner embedded `json:"inner,flatten"`
+	Outer int      `json:"outer"`
+}
+
+type marshalerField struct {
+	Raw rawJSON `json:"raw"`
+}
+
+// nullMarshalers hold json.Marshalers that produce null, and nil
+// pointers whose MarshalJSON and String methods have value receivers.
+type nullMarshalers struct {
+	Omit   rawJSON    `json:"omit,omitempty"`
+	Nested rawJSON    `json:"nested,omitnested"`
+	Any    any        `json:"any,omitempty"`
+	Quoted rawJSON    `json:"quoted,string"`
+	Time   *time.Time `json:"time"`
+	Stamp  any        `json:"stamp,string"`
+}
+
+func TestStructMatchesMap(t *testing.T) {
+	seven := 7
+	tests := []any{
+		structFieldsCase{
+			embedded: embedded{ID: 1},
+			Name:     "a",
+			NegZero:  math.Copysign(0, -1),
+			Empty:    []int{},
+			Zero:     &seven,
+			Raw:      map[string]int{"k": 1},
+			Loud:     "hi",
+			Quiet:    3,
+			Any:      embedded{ID: 2, Note: "n"},
+			Ptr:      &embedded{ID: 3},
+			List:     []embedded{{ID: 4}},
+			PtrList:  []*embedded{{ID: 5}, nil},
+			Keyed:    map[string]embedded{"a": {ID: 6}},
+			Atom:     "ok",
+		},
+		structFieldsCase{Any: rawJSON(`{"a":[1,2]}`)},
+		&structFieldsCase{Any: []int{1, 2}},
+		duplicateNames{embedded: embedded{ID: 1}, Other: 2},
+		flattened{Inner: embedded{ID: 1, Note: "n"}, Outer: 2},
+		marshalerField{Raw: `"text"`},
+		nullMarshalers{Omit: "null", Nested: "null", Any: rawJSON("null"), Quoted: "null", Stamp: (*time.Time)(nil)},
+		[]embedded{{ID: 1}, {}},
+		map[string]any{"payload": gatewayPayload{Op: 1, D: identify{Shard: []int{0, 1}}}},
+	}
+
+	e := NewEncoder()
+	for _, v := range tests {
+		got, err := e.PackE(v)
+		if err != nil {
+			t.Errorf("PackE(%T): %v", v, err)
+			continue
+		}
+
+		var old any = v
+		if reflect.ValueOf(v).Kind() != reflect.Slice && reflect.ValueOf(v).Kind() != reflect.Map {
+			old = NewStruct(v).Map()
+		}
+		want := e.Pack(old)
+
+		gotValue, err := NewDecoder().UnpackValue(got)
+		if err != nil {
+			t.Errorf("UnpackValue(%T): %v", v, err)
+			continue
+		}
+		wantValue, _ := NewDecoder().UnpackValue(want)
+		if !reflect.DeepEqual(gotValue, wantValue) {
+			t.Errorf("PackE(%T) = %v\nwant %v", v, gotValue, wantValue)
+		}
+	}
+}
+
+func TestNullMarshalers(t *testing.T) {
+	v := nullMarshalers{Omit: "null", Nested: "null", Any: rawJSON("null"), Quoted: "null", Stamp: (*time.Time)(nil)}
+	frame, err := Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out, err := NewDecoder().Unpack(frame)
+	if want := `{"nested":null,"quoted":null,"time":null,"stamp":null}`; err != nil || !jsonEqual(string(out), want) {
+		t.Errorf("Marshal = %s, %v; want %s", out, err, want)
+	}
+}
+
+// jsonEqual compares JSON documents regardless of object member order.
+func jsonEqual(a, b string) bool {
+	var va, vb any
+	if json.Unmarshal([]byte(a), &va) != nil || json.Unmarshal([]byte(b), &vb) != nil {
+		return false
+	}
+	return reflect.DeepEqual(va, vb)
+}
+
+func TestStructFieldError(t *testing.T) {
+	v := gatewayPayload{D: presenceUpdate{Activities: []activity{{}, {Name: "x"}}, Since: new(int64)}}
+	v.D = map[string]any{"list": []gatewayPayload{{}, {D: math.Inf(1)}}}
+
+	_, err := Marshal(v)
+	var ee *EncodeError
+	if !errors.As(err, &ee) || !errors.Is(err, ErrInvalidFloat) || ee.Path != "/d/list/1/d" {
+		t.Fatalf("Marshal = %v, want ErrInvalidFloat at /d/list/1/d", err)
+	}
+}
+
+func TestNumberConformance(t *testing.T) {
+	tests := []struct {
+		value any
+		hex   string
+		json  string
+	}{
+		{0, "836100", "0"},
+		{255, "8361ff", "255"},
+		{256, "836200000100", "256"},
+		{-1, "8362ffffffff", "-1"},
+		{int32(math.MinInt32), "836280000000", "-2147483648"},
+		{int32(math.MaxInt32), "83627fffffff", "2147483647"},
+		{int64(math.MaxInt32) + 1, "836e040000000080", "2147483648"},
+		{int64(math.MinInt32) - 1, "836e040101000080", "-2147483649"},
+		{int64(math.MinInt64), "836e08010000000000000080", `"-9223372036854775808"`},
+		{int64(math.MaxInt64), "836e0800ffffffffffffff7f", `"9223372036854775807"`},
+		{uint64(math.MaxUint64), "836e0800ffffffffffffffff", `"18446744073709551615"`},
+		{uint8(200), "8361c8", "200"},
+		{1.5, "83463ff8000000000000", "1.5"},
+		{0.0, "83460000000000000000", "0"},
+		{math.Copysign(0, -1), "83468000000000000000", "-0"},
+		{-2.5e-5, "8346befa36e2eb1c432d", "-0.000025"},
+		{float32(0.1), "83463fb99999a0000000", "0.10000000149011612"},
+	}
+
+	e := NewEncoder()
+	for _, tt := range tests {
+		got, err := e.PackE(tt.value)
+		if err != nil || hex.EncodeToString(got) != tt.hex {
+			t.Errorf("PackE(%T(%v)) = %x, %v; want %s", tt.value, tt.value, got, err, tt.hex)
+			continue
+		}
+		out, err := NewDecoder().Unpack(got)
+		if err != nil || string(out) != tt.json {
+			t.Errorf("Unpack(%s) = %s, %v; want %s", tt.hex, out, err, tt.json)
+		}
+	}
+
+	for _, f := range []any{math.NaN(), math.Inf(1), float32(math.Inf(-1)), []any{math.NaN()}} {
+		if _, err := Marshal(f); !errors.Is(err, ErrInvalidFloat) {
+			t.Errorf("Marshal(%v) = %v, want ErrInvalidFloat", f, err)
+		}
+	}
+
+	nan := binary.BigEndian.AppendUint64([]byte{FORMAT_VERSION, NEW_FLOAT_EXT}, math.Float64bits(math.NaN()))
+	if _, err := NewDecoder().Unpack(nan); !errors.Is(err, ErrInvalidFloat) {
+		t.Errorf("Unpack(NaN) = %v, want ErrInvalidFloat", err)
+	}
+}
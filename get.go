@@ -0,0 +1,133 @@
+package erlpack
+
+import (
+	"errors"
+	"strconv"
+)
+
+var ErrPathNotFound = errors.New("path not found")
+
+// Raw is a single encoded term without the version byte.
+type Raw []byte
+
+// Frame returns the term as a standalone ETF frame.
+func (r Raw) Frame() []byte {
+	return append([]byte{FORMAT_VERSION}, r...)
+}
+
+func (r Raw) JSON() ([]byte, error) {
+	return newScanDecoder().Unpack(r.Frame())
+}
+
+func (r Raw) Value() (any, error) {
+	return newScanDecoder().UnpackValue(r.Frame())
+}
+
+func (r Raw) Unmarshal(v any) error {
+	return newScanDecoder().Unmarshal(r.Frame(), v)
+}
+
+// Get returns the term found by following path through map keys and
+// list or tuple indexes, skipping every other subterm by its length
+// prefixes. Map keys are matched in the form Unpack renders them.
+func Get(data []byte, path ...string) (Raw, error) {
+	return newScanDecoder().Get(data, path...)
+}
+
+func (d *Decoder) Get(data []byte, path ...string) (Raw, error) {
+	if err := d.begin(data); err != nil {
+		return nil, err
+	}
+
+	// Only a whole frame can be compressed, so the path starts inside the
+	// inflated term.
+	if len(d.data) > 0 && d.data[0] == COMPRESSED {
+		d.offset = 1
+		out, _, err := d.inflate()
+		if err != nil {
+			return nil, d.fail(err, COMPRESSED, tagNames[COMPRESSED])
+		}
+		d.data, d.offset = out, 0
+	}
+
+	for i, key := range path {
+		if err := d.descend(key); err != nil {
+			return nil, prefixPath(err, path[:i])
+		}
+	}
+
+	start := d.offset
+	if err := d.skip(); err != nil {
+		return nil, prefixPath(err, path)
+	}
+	return Raw(d.data[start:d.offset]), nil
+}
+
+// prefixPath adds the keys Get has descended through to the path of err,
+// so that it is the same path a full decode reports.
+func prefixPath(err error, keys []string) error {
+	for i := len(keys) - 1; i >= 0; i-- {
+		err = decodePath(err, keys[i])
+	}
+	return err
+}
+
+// descend moves the decoder from the start of a container to the start of
+// its member named key. Each container is entered under the same depth and
+// length limits as a full decode.
+func (d *Decoder) descend(key string) error {
+	tag, err := d.read8()
+	if err != nil {
+		return d.fail(err, 0, "tag")
+	}
+
+	switch tag {
+	case MAP_EXT:
+		n, err := d.readLength(4)
+		if err == nil {
+			err = d.enter(n, 2)
+		}
+		if err != nil {
+			return d.fail(err, tag, tagNames[tag])
+		}
+		for range n {
+			keyOff := d.offset
+			k, err := d.decodeKey()
+			if err != nil {
+				return d.failKey(err, keyOff)
+			}
+			if string(k) == key {
+				return nil
+			}
+			if err := d.skip(); err != nil {
+				return decodePath(err, d.keyAt(keyOff))
+			}
+		}
+	case LIST_EXT, SMALL_TUPLE_EXT, LARGE_TUPLE_EXT:
+		width := 4
+		if tag == SMALL_TUPLE_EXT {
+			width = 1
+		}
+		n, err := d.readLength(width)
+		if err == nil {
+			err = d.enter(n, 1)
+		}
+		if err != nil {
+			return d.fail(err, tag, tagNames[tag])
+		}
+		idx, err := strconv.ParseUint(key, 10, 32)
+		if err != nil || idx >= uint64(n) {
+			break
+		}
+		for i := range idx {
+			if err := d.skip(); err != nil {
+				return decodePath(err, strconv.FormatUint(i, 10))
+			}
+		}
+		return nil
+	case COMPRESSED:
+		return d.fail(ErrNestedCompressed, tag, tagNames[tag])
+	}
+
+	return decodePath(d.fail(ErrPathNotFound, tag, "container"), key)
+}
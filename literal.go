@@ -0,0 +1,738 @@
+package erlpack
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+	"slices"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+var (
+	ErrSyntax = errors.New("invalid term syntax")
+
+	byteMask = big.NewInt(0xff)
+)
+
+type literalParser struct {
+	src string
+	pos int
+}
+
+// ParseLiteral parses a term written in Erlang syntax, such as
+// {reply,[1,2,3],#{<<"k">> => v}}, into the Term term_to_binary would
+// encode for it. Pids, ports, references and local funs have no literal
+// syntax and are rejected; a trailing full stop, as the shell prints it, is
+// accepted. Map keys are sorted into term order, which matches
+// term_to_binary for maps of up to 32 keys; larger maps are written in
+// hash order by the runtime.
+func ParseLiteral(src string) (Term, error) {
+	p := &literalParser{src: src}
+	t, err := p.term()
+	if err != nil {
+		return nil, err
+	}
+
+	p.space()
+	if p.peek() == '.' {
+		p.pos++
+		p.space()
+	}
+	if p.pos < len(p.src) {
+		return nil, p.errorf("unexpected %q after term", p.src[p.pos:])
+	}
+	return t, nil
+}
+
+// PackLiteral parses src with ParseLiteral and returns it as an ETF frame.
+func PackLiteral(src string) ([]byte, error) {
+	t, err := ParseLiteral(src)
+	if err != nil {
+		return nil, err
+	}
+	return termEncoder.appendPack(nil, t)
+}
+
+func (p *literalParser) errorf(format string, args ...any) error {
+	return fmt.Errorf("%w: %s at offset %d", ErrSyntax, fmt.Sprintf(format, args...), p.pos)
+}
+
+func (p *literalParser) peek() byte {
+	if p.pos < len(p.src) {
+		return p.src[p.pos]
+	}
+	return 0
+}
+
+// space skips whitespace and % comments.
+func (p *literalParser) space() {
+	for p.pos < len(p.src) {
+		switch p.src[p.pos] {
+		case ' ', '\t', '\n', '\r', '\f', '\v':
+			p.pos++
+		case '%':
+			for p.pos < len(p.src) && p.src[p.pos] != '\n' {
+				p.pos++
+			}
+		default:
+			return
+		}
+	}
+}
+
+func (p *literalParser) expect(s string) error {
+	p.space()
+	if !strings.HasPrefix(p.src[p.pos:], s) {
+		return p.errorf("expected %q", s)
+	}
+	p.pos += len(s)
+	return nil
+}
+
+// accept consumes s if it comes next.
+func (p *literalParser) accept(s string) bool {
+	p.space()
+	if strings.HasPrefix(p.src[p.pos:], s) {
+		p.pos += len(s)
+		return true
+	}
+	return false
+}
+
+func (p *literalParser) term() (Term, error) {
+	p.space()
+	c := p.peek()
+
+	switch {
+	case c == 0:
+		return nil, p.errorf("unexpected end of input")
+	case c == '{':
+		p.pos++
+		elems, err := p.terms("}")
+		if err != nil {
+			return nil, err
+		}
+		out := make(Tuple, len(elems))
+		for i, t := range elems {
+			out[i] = t
+		}
+		return out, nil
+	case c == '[':
+		return p.list()
+	case c == '#':
+		p.pos++
+		if err := p.expect("{"); err != nil {
+			return nil, err
+		}
+		return p.mapTerm()
+	case c == '<':
+		if !strings.HasPrefix(p.src[p.pos:], "<<") {
+			return nil, p.errorf("pids have no literal form")
+		}
+		p.pos += 2
+		return p.binary()
+	case c == '"':
+		return p.str()
+	case c == '\'':
+		s, err := p.quoted('\'')
+		if err != nil {
+			return nil, err
+		}
+		return p.atom(s)
+	case c == '$' || c == '-' || c == '+' || c >= '0' && c <= '9':
+		return p.number()
+	case c >= 'a' && c <= 'z':
+		name := p.ident()
+		if name == "fun" {
+			return p.export()
+		}
+		return p.atom(name)
+	case c >= 'A' && c <= 'Z' || c == '_':
+		start := p.pos
+		name := p.ident()
+		p.pos = start
+		return nil, p.errorf("variable %s in term", name)
+	default:
+		return nil, p.errorf("unexpected %q", c)
+	}
+}
+
+func (p *literalParser) ident() string {
+	start := p.pos
+	for p.pos < len(p.src) && isIdentByte(p.src[p.pos]) {
+		p.pos++
+	}
+	return p.src[start:p.pos]
+}
+
+func (p *literalParser) atom(s string) (Term, error) {
+	if utf8.RuneCountInString(s) > 255 {
+		return nil, p.errorf("atom longer than 255 characters")
+	}
+	return Atom(s), nil
+}
+
+// terms parses comma-separated terms up to close.
+func (p *literalParser) terms(close string) ([]Term, error) {
+	var out []Term
+	if p.accept(close) {
+		return out, nil
+	}
+	for {
+		t, err := p.term()
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, t)
+		if p.accept(close) {
+			return out, nil
+		}
+		if err := p.expect(","); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// list parses a list literal. Proper lists of bytes become charlists, as
+// term_to_binary writes them as STRING_EXT.
+func (p *literalParser) list() (Term, error) {
+	p.pos++
+	var l List
+	if p.accept("]") {
+		return l, nil
+	}
+	for {
+		t, err := p.term()
+		if err != nil {
+			return nil, err
+		}
+		l.Elems = append(l.Elems, t)
+		if p.accept("|") {
+			if l.Tail, err = p.term(); err != nil {
+				return nil, err
+			}
+			if err := p.expect("]"); err != nil {
+				return nil, err
+			}
+			return normalizeList(l), nil
+		}
+		if p.accept("]") {
+			return normalizeList(l), nil
+		}
+		if err := p.expect(","); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// normalizeList folds a tail that is itself a list into the elements, so
+// [1|[2]] encodes like [1,2].
+func normalizeList(l List) Term {
+	for l.Tail != nil {
+		switch tail := l.Tail.(type) {
+		case List:
+			l.Elems = append(l.Elems, tail.Elems...)
+			l.Tail = tail.Tail
+			continue
+		case Charlist:
+			for _, c := range tail {
+				l.Elems = append(l.Elems, Int(c))
+			}
+			l.Tail = nil
+		}
+		break
+	}
+	if l.Tail != nil || len(l.Elems) > 0xffff {
+		return l
+	}
+
+	s := make(Charlist, len(l.Elems))
+	for i, t := range l.Elems {
+		c, ok := t.(Int)
+		if !ok || c < 0 || c > 255 {
+			return l
+		}
+		s[i] = byte(c)
+	}
+	if len(s) == 0 {
+		return List{}
+	}
+	return s
+}
+
+func (p *literalParser) mapTerm() (Term, error) {
+	var m Map
+	if p.accept("}") {
+		return m, nil
+	}
+	for {
+		k, err := p.term()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expect("=>"); err != nil {
+			return nil, err
+		}
+		v, err := p.term()
+		if err != nil {
+			return nil, err
+		}
+
+		i := slices.IndexFunc(m, func(pair Pair) bool { return compareTerms(pair.Key, k) == 0 })
+		if i >= 0 {
+			m[i].Value = v
+		} else {
+			m = append(m, Pair{Key: k, Value: v})
+		}
+
+		if p.accept("}") {
+			break
+		}
+		if err := p.expect(","); err != nil {
+			return nil, err
+		}
+	}
+
+	slices.SortStableFunc(m, func(a, b Pair) int { return compareTerms(a.Key, b.Key) })
+	return m, nil
+}
+
+// binary parses the segments of a binary after the opening <<. Each
+// segment is a string, written as Latin-1 unless marked /utf8, or an
+// integer with an optional size in bits.
+func (p *literalParser) binary() (Term, error) {
+	out := Binary{}
+	if p.accept(">>") {
+		return out, nil
+	}
+	for {
+		p.space()
+		if p.peek() == '"' {
+			s, err := p.quoted('"')
+			if err != nil {
+				return nil, err
+			}
+			if p.accept("/utf8") {
+				out = append(out, s...)
+			} else {
+				for _, r := range s {
+					out = append(out, byte(r))
+				}
+			}
+		} else {
+			v, err := p.integer()
+			if err != nil {
+				return nil, err
+			}
+			size := 8
+			if p.accept(":") {
+				p.space()
+				start := p.pos
+				n, err := strconv.Atoi(p.digits())
+				if err != nil || n <= 0 || n%8 != 0 {
+					p.pos = start
+					return nil, p.errorf("segment size must be a positive multiple of 8")
+				}
+				size = n
+			}
+			for shift := size - 8; shift >= 0; shift -= 8 {
+				b := new(big.Int).Rsh(v, uint(shift))
+				out = append(out, byte(b.And(b, byteMask).Int64()))
+			}
+		}
+
+		if p.accept(">>") {
+			return out, nil
+		}
+		if err := p.expect(","); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// str parses a string literal, joining adjacent literals as Erlang does.
+func (p *literalParser) str() (Term, error) {
+	var runes []rune
+	for p.peek() == '"' {
+		s, err := p.quoted('"')
+		if err != nil {
+			return nil, err
+		}
+		runes = append(runes, []rune(s)...)
+		p.space()
+	}
+
+	l := List{Elems: make([]Term, len(runes))}
+	for i, r := range runes {
+		l.Elems[i] = Int(r)
+	}
+	return normalizeList(l), nil
+}
+
+func (p *literalParser) quoted(quote byte) (string, error) {
+	p.pos++
+	var b strings.Builder
+	for {
+		if p.pos >= len(p.src) {
+			return "", p.errorf("unterminated %c", quote)
+		}
+		c := p.src[p.pos]
+		if c == quote {
+			p.pos++
+			return b.String(), nil
+		}
+		r, err := p.char()
+		if err != nil {
+			return "", err
+		}
+		b.WriteRune(r)
+	}
+}
+
+// char reads one possibly escaped character of a string, quoted atom or
+// $ literal.
+func (p *literalParser) char() (rune, error) {
+	r, n := utf8.DecodeRuneInString(p.src[p.pos:])
+	if r == utf8.RuneError && n <= 1 {
+		return 0, p.errorf("invalid UTF-8")
+	}
+	p.pos += n
+	if r != '\\' {
+		return r, nil
+	}
+
+	if p.pos >= len(p.src) {
+		return 0, p.errorf("unterminated escape")
+	}
+	c := p.src[p.pos]
+	p.pos++
+	switch c {
+	case 'n':
+		return '\n', nil
+	case 'r':
+		return '\r', nil
+	case 't':
+		return '\t', nil
+	case 'v':
+		return '\v', nil
+	case 'b':
+		return '\b', nil
+	case 'f':
+		return '\f', nil
+	case 'e':
+		return 0x1b, nil
+	case 's':
+		return ' ', nil
+	case 'd':
+		return 0x7f, nil
+	case '^':
+		if p.pos >= len(p.src) {
+			return 0, p.errorf("unterminated escape")
+		}
+		p.pos++
+		return rune(p.src[p.pos-1] & 0x1f), nil
+	case 'x':
+		start := p.pos - 2
+		var hex string
+		if p.peek() == '{' {
+			end := strings.IndexByte(p.src[p.pos:], '}')
+			if end < 0 {
+				return 0, p.errorf("unterminated \\x{")
+			}
+			hex = p.src[p.pos+1 : p.pos+end]
+			p.pos += end + 1
+		} else {
+			hex = p.src[p.pos:min(p.pos+2, len(p.src))]
+			p.pos += len(hex)
+		}
+		v, err := strconv.ParseUint(hex, 16, 21)
+		if err != nil || v > utf8.MaxRune {
+			p.pos = start
+			return 0, p.errorf("invalid \\x escape")
+		}
+		return rune(v), nil
+	case '0', '1', '2', '3', '4', '5', '6', '7':
+		v := rune(c - '0')
+		for range 2 {
+			if c := p.peek(); c >= '0' && c <= '7' {
+				v = v*8 + rune(c-'0')
+				p.pos++
+			}
+		}
+		return v, nil
+	default:
+		p.pos--
+		r, n := utf8.DecodeRuneInString(p.src[p.pos:])
+		p.pos += n
+		return r, nil
+	}
+}
+
+func (p *literalParser) digits() string {
+	start := p.pos
+	for p.pos < len(p.src) && (isIdentByte(p.src[p.pos]) && p.src[p.pos] != '@') {
+		p.pos++
+	}
+	return p.src[start:p.pos]
+}
+
+// integer parses an integer literal with an optional sign, base prefix
+// such as 16#ff, or $ character syntax.
+func (p *literalParser) integer() (*big.Int, error) {
+	p.space()
+	neg := false
+	if c := p.peek(); c == '-' || c == '+' {
+		neg = c == '-'
+		p.pos++
+		p.space()
+	}
+
+	v := new(big.Int)
+	if p.peek() == '$' {
+		p.pos++
+		if p.pos >= len(p.src) {
+			return nil, p.errorf("unterminated character literal")
+		}
+		r, err := p.char()
+		if err != nil {
+			return nil, err
+		}
+		v.SetInt64(int64(r))
+	} else {
+		start := p.pos
+		text := strings.ReplaceAll(p.digits(), "_", "")
+		base := 10
+		if p.peek() == '#' {
+			b, err := strconv.Atoi(text)
+			if err != nil || b < 2 || b > 36 {
+				return nil, p.errorf("invalid base %q", text)
+			}
+			p.pos++
+			base, text = b, strings.ReplaceAll(p.digits(), "_", "")
+		}
+		if _, ok := v.SetString(text, base); !ok {
+			p.pos = start
+			return nil, p.errorf("invalid integer")
+		}
+	}
+
+	if neg {
+		v.Neg(v)
+	}
+	return v, nil
+}
+
+func (p *literalParser) number() (Term, error) {
+	start := p.pos
+	v, err := p.integer()
+	if err != nil {
+		return nil, err
+	}
+
+	// A fraction or exponent only follows plain decimal digits.
+	if p.peek() == '.' && p.pos+1 < len(p.src) && p.src[p.pos+1] >= '0' && p.src[p.pos+1] <= '9' &&
+		!strings.ContainsAny(p.src[start:p.pos], "#$") {
+		p.pos++
+		p.digits()
+		if c := p.peek(); c == '-' || c == '+' {
+			if e := p.src[p.pos-1]; e == 'e' || e == 'E' {
+				p.pos++
+				p.digits()
+			}
+		}
+		text := strings.ReplaceAll(p.src[start:p.pos], "_", "")
+		text = strings.Join(strings.Fields(text), "")
+		f, err := strconv.ParseFloat(text, 64)
+		if err != nil || !isFinite(f) {
+			p.pos = start
+			return nil, p.errorf("invalid float")
+		}
+		return Float(f), nil
+	}
+
+	if v.IsInt64() {
+		return Int(v.Int64()), nil
+	}
+	return BigInt{v}, nil
+}
+
+// export parses fun Module:Function/Arity.
+func (p *literalParser) export() (Term, error) {
+	var x Export
+	names := [2]*Term{&x.Module, &x.Function}
+	for i, name := range names {
+		if i > 0 {
+			if err := p.expect(":"); err != nil {
+				return nil, err
+			}
+		}
+		p.space()
+		var s string
+		switch c := p.peek(); {
+		case c == '\'':
+			var err error
+			if s, err = p.quoted('\''); err != nil {
+				return nil, err
+			}
+		case c >= 'a' && c <= 'z':
+			s = p.ident()
+		default:
+			return nil, p.errorf("local funs have no literal form")
+		}
+		*name = Atom(s)
+	}
+	if err := p.expect("/"); err != nil {
+		return nil, err
+	}
+	p.space()
+	start := p.pos
+	arity, err := strconv.ParseUint(p.digits(), 10, 8)
+	if err != nil {
+		p.pos = start
+		return nil, p.errorf("invalid arity")
+	}
+	x.Arity = uint8(arity)
+	return x, nil
+}
+
+// termClass ranks terms in Erlang's order: number < atom < reference <
+// fun < port < pid < tuple < map < nil < list < bitstring.
+func termClass(t Term) int {
+	switch v := unwrap(t).(type) {
+	case Int, BigInt, Float:
+		return 0
+	case Atom:
+		return 1
+	case Ref:
+		return 2
+	case Fun, Export:
+		return 3
+	case Port:
+		return 4
+	case Pid:
+		return 5
+	case Tuple:
+		return 6
+	case Map:
+		return 7
+	case List:
+		if len(v.Elems) == 0 && v.Tail == nil {
+			return 8
+		}
+		return 9
+	case Charlist:
+		if len(v) == 0 {
+			return 8
+		}
+		return 9
+	case Binary:
+		return 10
+	}
+	return 11
+}
+
+// compareTerms orders terms the way flatmap keys are sorted, where an
+// integer sorts before a float of equal value. It is only given parsed
+// keys, whose tuples hold Terms.
+func compareTerms(a, b Term) int {
+	a, b = unwrap(a), unwrap(b)
+	if c := termClass(a) - termClass(b); c != 0 {
+		return c
+	}
+
+	switch x := a.(type) {
+	case Int, BigInt, Float:
+		if c := numberOf(a).Cmp(numberOf(b)); c != 0 {
+			return c
+		}
+		_, af := a.(Float)
+		_, bf := b.(Float)
+		switch {
+		case !af && bf:
+			return -1
+		case af && !bf:
+			return 1
+		}
+		return 0
+	case Atom:
+		return strings.Compare(string(x), string(b.(Atom)))
+	case Binary:
+		return strings.Compare(string(x), string(b.(Binary)))
+	case Tuple:
+		y := b.(Tuple)
+		if c := len(x) - len(y); c != 0 {
+			return c
+		}
+		for i := range x {
+			if c := compareTerms(x[i].(Term), y[i].(Term)); c != 0 {
+				return c
+			}
+		}
+		return 0
+	case Map:
+		y := b.(Map)
+		if c := len(x) - len(y); c != 0 {
+			return c
+		}
+		for i := range x {
+			if c := compareTerms(x[i].Key, y[i].Key); c != 0 {
+				return c
+			}
+		}
+		for i := range x {
+			if c := compareTerms(x[i].Value, y[i].Value); c != 0 {
+				return c
+			}
+		}
+		return 0
+	case List, Charlist:
+		xs, xt := listParts(a)
+		ys, yt := listParts(b)
+		n := min(len(xs), len(ys))
+		for i := range n {
+			if c := compareTerms(xs[i], ys[i]); c != 0 {
+				return c
+			}
+		}
+		return compareTerms(listRest(xs[n:], xt), listRest(ys[n:], yt))
+	}
+	return 0
+}
+
+func numberOf(t Term) *big.Float {
+	switch v := t.(type) {
+	case Int:
+		return new(big.Float).SetInt64(int64(v))
+	case BigInt:
+		return new(big.Float).SetInt(v.Int)
+	case Float:
+		return big.NewFloat(float64(v))
+	}
+	return new(big.Float)
+}
+
+func listParts(t Term) ([]Term, Term) {
+	if s, ok := t.(Charlist); ok {
+		elems := make([]Term, len(s))
+		for i, c := range s {
+			elems[i] = Int(c)
+		}
+		return elems, nil
+	}
+	l := t.(List)
+	return l.Elems, l.Tail
+}
+
+// listRest returns what follows a common prefix of two lists being
+// compared: the remaining cells, or the tail once they run out.
+func listRest(elems []Term, tail Term) Term {
+	if len(elems) > 0 {
+		return List{Elems: elems, Tail: tail}
+	}
+	if tail == nil {
+		return List{}
+	}
+	return tail
+}
@@ -0,0 +1,135 @@
+package erlpack
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// literalGolden pairs Erlang source with the bytes term_to_binary writes
+// for it, in hex with spaces between terms for readability.
+var literalGolden = []struct {
+	src string
+	hex string
+}{
+	// Tuples, atoms and funs.
+	{`{reply, [1,2,3], #{<<"k">> => v}}`, "83 6803 77057265706c79 6b0003010203 74000000016d000000016b 770176"},
+	{`{}`, "83 6800"},
+	{`ok.`, "83 77026f6b"},
+	{`'hello world'`, "83 770b68656c6c6f20776f726c64"},
+	{`'日本'`, "83 7706e697a5e69cac"},
+	{`fun lists:map/2`, "83 71 77056c69737473 77036d6170 6102"},
+
+	// Map keys in term order: number < atom < tuple < binary, with an
+	// integer before the float of equal value.
+	{`#{<<"z">> => 3, {t} => 4, b => 1, a => 2, 1 => x}`, "83 7400000005 6101 770178 770161 6102 770162 6101 6801770174 6104 6d000000017a 6103"},
+	{`#{1.0 => a, 1 => b}`, "83 7400000002 6101 770162 463ff0000000000000 770161"},
+	{`#{a => 1, a => 2}`, "83 7400000001 770161 6102"},
+
+	// Strings and charlists.
+	{`"abc"`, "83 6b0003616263"},
+	{`""`, "83 6a"},
+	{`[]`, "83 6a"},
+	{`"a" "b"`, "83 6b00026162"},
+	{`[$a, 98]`, "83 6b00026162"},
+	{`[256]`, "83 6c00000001 6200000100 6a"},
+	{`"日本"`, "83 6c00000002 62000065e5 620000672c 6a"},
+
+	// Improper lists, with list tails folded into the elements.
+	{`[1|2]`, "83 6c00000001 6101 6102"},
+	{`[1,2|[3]]`, "83 6b0003010203"},
+	{`[1|[]]`, "83 6b000101"},
+	{`[a|"bc"]`, "83 6c00000003 770161 6162 6163 6a"},
+	{`[[]|a]`, "83 6c00000001 6a 770161"},
+
+	// Integers and bignums.
+	{`255`, "83 61ff"},
+	{`256`, "83 6200000100"},
+	{`-1`, "83 62ffffffff"},
+	{`16#FF`, "83 61ff"},
+	{`$a`, "83 6161"},
+	{`-2147483648`, "83 6280000000"},
+	{`2147483648`, "83 6e040000000080"},
+	{`1_000_000_000_000`, "83 6e0500 0010a5d4e8"},
+	{`18446744073709551616`, "83 6e0900000000000000000001"},
+	{`-9223372036854775809`, "83 6e08010100000000000080"},
+	{"16#1" + strings.Repeat("0", 512), "83 6f0000010100" + strings.Repeat("00", 256) + "01"},
+
+	// Floats.
+	{`1.5`, "83 463ff8000000000000"},
+	{`-0.0`, "83 468000000000000000"},
+	{`1.0e3`, "83 46408f400000000000"},
+
+	// Binaries: Latin-1 strings unless /utf8, and sized integer segments.
+	{`<<>>`, "83 6d00000000"},
+	{`<<1:16, -1, "a">>`, "83 6d00000004 0001ff61"},
+	{`<<"é">>`, "83 6d00000001 e9"},
+	{`<<"é"/utf8>>`, "83 6d00000002 c3a9"},
+	{`<<256:16, -1:32>>`, "83 6d00000006 0100ffffffff"},
+
+	// Escapes.
+	{`"\n\t\e\s\d\101\x41\^A"`, "83 6b0008 0a091b207f414101"},
+	{`"\x{1F600}"`, "83 6c00000001 620001f600 6a"},
+	{`'a\'b'`, "83 7703612762"},
+	{`$\n`, "83 610a"},
+}
+
+func TestPackLiteralGolden(t *testing.T) {
+	for _, tt := range literalGolden {
+		want, err := hex.DecodeString(strings.ReplaceAll(tt.hex, " ", ""))
+		if err != nil {
+			t.Fatalf("%s: %v", tt.src, err)
+		}
+
+		got, err := PackLiteral(tt.src)
+		if err != nil || !bytes.Equal(got, want) {
+			t.Errorf("PackLiteral(%.40s) = % x, %v\nwant % x", tt.src, got, err, want)
+			continue
+		}
+
+		// The printed form parses back to the same bytes.
+		text, err := Format(want)
+		if err != nil {
+			t.Errorf("Format(%.40s): %v", tt.src, err)
+			continue
+		}
+		if again, err := PackLiteral(text); err != nil || !bytes.Equal(again, want) {
+			t.Errorf("PackLiteral(Format(%.40s)) = % x, %v from %.40s", tt.src, again, err, text)
+		}
+	}
+}
+
+func TestParseLiteralErrors(t *testing.T) {
+	for _, tt := range []struct {
+		src    string
+		offset int
+	}{
+		{``, 0},
+		{`{a,`, 3},
+		{`[1,2`, 4},
+		{`[1|2|3]`, 4},
+		{`#{a => }`, 7},
+		{`#{a => 1`, 8},
+		{`{a} b`, 4},
+		{`X`, 0},
+		{`{a, _}`, 4},
+		{`<0.1.2>`, 0},
+		{`"abc`, 4},
+		{`<<1:7>>`, 4},
+		{`1.5e999`, 0},
+		{`16#g`, 0},
+		{`'\x{110000}'`, 1},
+		{`fun f/1`, 5},
+		{`fun lists:map/x`, 14},
+		{`'` + strings.Repeat("x", 256) + `'`, 258},
+	} {
+		_, err := ParseLiteral(tt.src)
+		suffix := " at offset " + strconv.Itoa(tt.offset)
+		if !errors.Is(err, ErrSyntax) || !strings.HasSuffix(err.Error(), suffix) {
+			t.Errorf("ParseLiteral(%.20q) = %v, want ErrSyntax%s", tt.src, err, suffix)
+		}
+	}
+}
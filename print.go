@@ -0,0 +1,549 @@
+package erlpack
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+const defaultWidth = 80
+
+var erlangReserved = map[string]bool{
+	"after": true, "and": true, "andalso": true, "band": true, "begin": true,
+	"bnot": true, "bor": true, "bsl": true, "bsr": true, "bxor": true,
+	"case": true, "catch": true, "cond": true, "div": true, "else": true,
+	"end": true, "fun": true, "if": true, "let": true, "maybe": true,
+	"not": true, "of": true, "or": true, "orelse": true, "receive": true,
+	"rem": true, "try": true, "when": true, "xor": true,
+}
+
+// Printer renders terms as Erlang source text the way io:format("~p")
+// does, or as Elixir source text when Elixir is set.
+type Printer struct {
+	Elixir bool
+
+	// Indent, when non-empty, breaks containers that do not fit in Width
+	// columns over several lines, one member per line, indenting each level
+	// of nesting by Indent. Width defaults to 80.
+	Indent string
+	Width  int
+}
+
+func NewPrinter() *Printer {
+	return &Printer{}
+}
+
+// Format renders an ETF frame in Erlang syntax on a single line.
+func Format(data []byte) (string, error) {
+	out, err := NewPrinter().Format(data)
+	return string(out), err
+}
+
+func (p *Printer) Format(data []byte) ([]byte, error) {
+	t, err := ParseTerm(data)
+	if err != nil {
+		return nil, err
+	}
+	return p.AppendTerm(nil, t)
+}
+
+// AppendTerm appends the text of t to dst. Tuple members that are not
+// Terms are converted the way Marshal encodes them, and an error is
+// returned if one cannot be encoded.
+func (p *Printer) AppendTerm(dst []byte, t Term) ([]byte, error) {
+	return p.appendTerm(dst, t, 0)
+}
+
+func (p *Printer) appendTerm(dst []byte, t Term, depth int) ([]byte, error) {
+	switch v := t.(type) {
+	case nil:
+		return append(dst, "[]"...), nil
+	case Atom:
+		return p.appendAtom(dst, v), nil
+	case Int:
+		return strconv.AppendInt(dst, int64(v), 10), nil
+	case BigInt:
+		if v.Int == nil {
+			return p.appendAtom(dst, "nil"), nil
+		}
+		return v.Int.Append(dst, 10), nil
+	case Float:
+		return appendFloatText(dst, float64(v)), nil
+	case Binary:
+		return p.appendBinary(dst, v), nil
+	case Charlist:
+		if len(v) == 0 {
+			return append(dst, "[]"...), nil
+		}
+		if p.printable(v) {
+			return p.appendCharlist(dst, v), nil
+		}
+		elems := make([]Term, len(v))
+		for i, c := range v {
+			elems[i] = Int(c)
+		}
+		return p.appendList(dst, List{Elems: elems}, depth)
+	case List:
+		if s, ok := p.listString(v); ok {
+			return p.appendCharlist(dst, s), nil
+		}
+		return p.appendList(dst, v, depth)
+	case Tuple:
+		return p.appendSeq(dst, "{", "}", len(v), func(dst []byte, i int, p *Printer, depth int) ([]byte, error) {
+			t, err := termOf(v[i])
+			if err != nil {
+				return nil, err
+			}
+			return p.appendTerm(dst, t, depth)
+		}, depth)
+	case Map:
+		return p.appendMap(dst, v, depth)
+	case Pid:
+		if p.Elixir {
+			dst = append(dst, "#PID"...)
+		}
+		return fmt.Appendf(dst, "<0.%d.%d>", v.ID, v.Serial), nil
+	case Port:
+		return fmt.Appendf(dst, "#Port<0.%d>", v.ID), nil
+	case Ref:
+		if p.Elixir {
+			dst = append(dst, "#Reference<0"...)
+		} else {
+			dst = append(dst, "#Ref<0"...)
+		}
+		for i := len(v.ID) - 1; i >= 0; i-- {
+			dst = fmt.Appendf(dst, ".%d", v.ID[i])
+		}
+		return append(dst, '>'), nil
+	case Fun:
+		return p.appendFun(dst, v, depth)
+	case Export:
+		return p.appendExport(dst, v, depth)
+	case Encoded:
+		return p.appendTerm(dst, v.Term, depth)
+	default:
+		return fmt.Appendf(dst, "%v", t), nil
+	}
+}
+
+func (p *Printer) appendFun(dst []byte, f Fun, depth int) ([]byte, error) {
+	var err error
+	if p.Elixir {
+		dst = fmt.Appendf(dst, "#Function<%d.", f.Index)
+		if dst, err = p.appendTerm(dst, f.OldUniq, depth); err != nil {
+			return nil, err
+		}
+		dst = fmt.Appendf(dst, "/%d in ", f.Arity)
+		if dst, err = p.appendTerm(dst, f.Module, depth); err != nil {
+			return nil, err
+		}
+		return append(dst, '>'), nil
+	}
+
+	dst = append(dst, "#Fun<"...)
+	if dst, err = p.appendTerm(dst, f.Module, depth); err != nil {
+		return nil, err
+	}
+	dst = fmt.Appendf(dst, ".%d.", f.Index)
+	if dst, err = p.appendTerm(dst, f.OldUniq, depth); err != nil {
+		return nil, err
+	}
+	return append(dst, '>'), nil
+}
+
+func (p *Printer) appendExport(dst []byte, x Export, depth int) ([]byte, error) {
+	var err error
+	if p.Elixir {
+		dst = append(dst, '&')
+		if dst, err = p.appendTerm(dst, x.Module, depth); err != nil {
+			return nil, err
+		}
+		dst = append(dst, '.')
+		function, _ := atomOf(x.Function)
+		dst = appendElixirName(dst, string(function))
+	} else {
+		dst = append(dst, "fun "...)
+		if dst, err = p.appendTerm(dst, x.Module, depth); err != nil {
+			return nil, err
+		}
+		dst = append(dst, ':')
+		if dst, err = p.appendTerm(dst, x.Function, depth); err != nil {
+			return nil, err
+		}
+	}
+	return fmt.Appendf(dst, "/%d", x.Arity), nil
+}
+
+// termOf returns a Tuple member as a Term, encoding and parsing back
+// members built from plain Go values.
+func termOf(v any) (Term, error) {
+	if t, ok := v.(Term); ok {
+		return t, nil
+	}
+	b, err := termEncoder.rawPack(nil, v)
+	if err != nil {
+		return nil, err
+	}
+	d := newScanDecoder()
+	d.data = b
+	return d.decodeTerm()
+}
+
+func (p *Printer) sep() string {
+	if p.Elixir {
+		return ", "
+	}
+	return ","
+}
+
+func (p *Printer) appendList(dst []byte, l List, depth int) ([]byte, error) {
+	bar := "|"
+	if p.Elixir {
+		bar = " | "
+	}
+	return p.appendSeq(dst, "[", "]", len(l.Elems), func(dst []byte, i int, p *Printer, depth int) ([]byte, error) {
+		dst, err := p.appendTerm(dst, l.Elems[i], depth)
+		if err != nil || i < len(l.Elems)-1 || l.Tail == nil {
+			return dst, err
+		}
+		dst = append(dst, bar...)
+		return p.appendTerm(dst, l.Tail, depth)
+	}, depth)
+}
+
+func (p *Printer) appendMap(dst []byte, m Map, depth int) ([]byte, error) {
+	open := "#{"
+	if p.Elixir {
+		open = "%{"
+	}
+
+	short := p.Elixir
+	for i := range m {
+		if a, ok := atomOf(m[i].Key); !ok || !isElixirIdent(string(a)) {
+			short = false
+			break
+		}
+	}
+
+	return p.appendSeq(dst, open, "}", len(m), func(dst []byte, i int, p *Printer, depth int) ([]byte, error) {
+		if short {
+			a, _ := atomOf(m[i].Key)
+			dst = append(dst, a...)
+			dst = append(dst, ": "...)
+		} else {
+			var err error
+			if dst, err = p.appendTerm(dst, m[i].Key, depth); err != nil {
+				return nil, err
+			}
+			dst = append(dst, " => "...)
+		}
+		return p.appendTerm(dst, m[i].Value, depth)
+	}, depth)
+}
+
+// appendSeq writes n members between open and close on one line, or one
+// member per line when indenting is enabled and the line would not fit.
+func (p *Printer) appendSeq(dst []byte, open, close string, n int, member func([]byte, int, *Printer, int) ([]byte, error), depth int) ([]byte, error) {
+	flat := *p
+	flat.Indent = ""
+
+	var err error
+	start := len(dst)
+	dst = append(dst, open...)
+	for i := range n {
+		if i > 0 {
+			dst = append(dst, p.sep()...)
+		}
+		if dst, err = member(dst, i, &flat, depth+1); err != nil {
+			return nil, err
+		}
+	}
+	dst = append(dst, close...)
+
+	if p.Indent == "" || n == 0 || p.fits(dst, start) {
+		return dst, nil
+	}
+
+	dst = append(dst[:start], open...)
+	for i := range n {
+		if i > 0 {
+			dst = append(dst, ',')
+		}
+		dst = p.appendNewline(dst, depth+1)
+		if dst, err = member(dst, i, p, depth+1); err != nil {
+			return nil, err
+		}
+	}
+	dst = p.appendNewline(dst, depth)
+	return append(dst, close...), nil
+}
+
+// fits reports whether the line holding dst[start:] stays within Width.
+func (p *Printer) fits(dst []byte, start int) bool {
+	width := p.Width
+	if width <= 0 {
+		width = defaultWidth
+	}
+	line := bytes.LastIndexByte(dst[:start], '\n') + 1
+	return utf8.RuneCount(dst[line:]) <= width
+}
+
+func (p *Printer) appendNewline(dst []byte, depth int) []byte {
+	dst = append(dst, '\n')
+	for range depth {
+		dst = append(dst, p.Indent...)
+	}
+	return dst
+}
+
+func (p *Printer) appendAtom(dst []byte, a Atom) []byte {
+	s := string(a)
+	if !p.Elixir {
+		if isErlangAtom(s) {
+			return append(dst, s...)
+		}
+		return p.appendQuoted(dst, s, '\'')
+	}
+
+	switch {
+	case s == "true" || s == "false" || s == "nil":
+		return append(dst, s...)
+	case isElixirAlias(s):
+		return append(dst, strings.TrimPrefix(s, "Elixir.")...)
+	}
+	dst = append(dst, ':')
+	return appendElixirName(dst, s)
+}
+
+func appendElixirName(dst []byte, s string) []byte {
+	if isElixirIdent(s) {
+		return append(dst, s...)
+	}
+	return (&Printer{Elixir: true}).appendQuoted(dst, s, '"')
+}
+
+func isErlangAtom(s string) bool {
+	if s == "" || s[0] < 'a' || s[0] > 'z' || erlangReserved[s] {
+		return false
+	}
+	for i := 1; i < len(s); i++ {
+		if !isIdentByte(s[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+func isElixirIdent(s string) bool {
+	if s == "" || !(s[0] >= 'a' && s[0] <= 'z' || s[0] == '_') {
+		return false
+	}
+	s = strings.TrimRight(s[1:], "?!")
+	for i := 0; i < len(s); i++ {
+		if !isIdentByte(s[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// isElixirAlias reports whether s is a module name such as Elixir.Foo.Bar,
+// which Elixir prints without the prefix.
+func isElixirAlias(s string) bool {
+	rest, ok := strings.CutPrefix(s, "Elixir.")
+	if !ok {
+		return false
+	}
+	for part := range strings.SplitSeq(rest, ".") {
+		if part == "" || part[0] < 'A' || part[0] > 'Z' {
+			return false
+		}
+		for i := 1; i < len(part); i++ {
+			if part[i] == '@' || !isIdentByte(part[i]) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func isIdentByte(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '_' || c == '@'
+}
+
+func (p *Printer) appendBinary(dst []byte, b Binary) []byte {
+	if len(b) == 0 {
+		return append(dst, "<<>>"...)
+	}
+
+	if utf8.Valid(b) && printableText(string(b)) {
+		if p.Elixir {
+			return p.appendQuoted(dst, string(b), '"')
+		}
+		dst = append(dst, "<<"...)
+		dst = p.appendQuoted(dst, string(b), '"')
+		if !isASCIIText(b) {
+			dst = append(dst, "/utf8"...)
+		}
+		return append(dst, ">>"...)
+	}
+
+	dst = append(dst, "<<"...)
+	for i, c := range b {
+		if i > 0 {
+			dst = append(dst, p.sep()...)
+		}
+		dst = strconv.AppendUint(dst, uint64(c), 10)
+	}
+	return append(dst, ">>"...)
+}
+
+func printableText(s string) bool {
+	for _, r := range s {
+		if !unicode.IsPrint(r) && !isEscape(r) {
+			return false
+		}
+	}
+	return true
+}
+
+func isASCIIText(b []byte) bool {
+	for _, c := range b {
+		if c >= utf8.RuneSelf {
+			return false
+		}
+	}
+	return true
+}
+
+// isEscape reports whether r has a single-letter escape in both Erlang
+// and Elixir strings.
+func isEscape(r rune) bool {
+	switch r {
+	case '\n', '\r', '\t', '\v', '\b', '\f', 0x1b:
+		return true
+	}
+	return false
+}
+
+// printable reports whether a charlist prints as a string: Latin-1 text
+// for Erlang, as ~p does by default, and ASCII text for Elixir.
+func (p *Printer) printable(s []byte) bool {
+	for _, c := range s {
+		switch {
+		case isEscape(rune(c)), c >= 32 && c <= 126:
+		case c >= 160 && !p.Elixir:
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// listString returns the characters of a proper list of integers that
+// prints as a string.
+func (p *Printer) listString(l List) ([]byte, bool) {
+	if len(l.Elems) == 0 || l.Tail != nil {
+		return nil, false
+	}
+	s := make([]byte, len(l.Elems))
+	for i, t := range l.Elems {
+		c, ok := unwrap(t).(Int)
+		if !ok || c < 0 || c > 255 {
+			return nil, false
+		}
+		s[i] = byte(c)
+	}
+	return s, p.printable(s)
+}
+
+func (p *Printer) appendCharlist(dst []byte, s []byte) []byte {
+	if p.Elixir {
+		dst = append(dst, "~c"...)
+	}
+	runes := make([]rune, len(s))
+	for i, c := range s {
+		runes[i] = rune(c)
+	}
+	return p.appendQuoted(dst, string(runes), '"')
+}
+
+func (p *Printer) appendQuoted(dst []byte, s string, quote byte) []byte {
+	dst = append(dst, quote)
+	for i, r := range s {
+		switch {
+		case r == '\\' || r == rune(quote):
+			dst = append(dst, '\\', byte(r))
+		case r == '#' && p.Elixir && quote == '"' && strings.HasPrefix(s[i+1:], "{"):
+			dst = append(dst, '\\', '#')
+		case r == '\n':
+			dst = append(dst, '\\', 'n')
+		case r == '\r':
+			dst = append(dst, '\\', 'r')
+		case r == '\t':
+			dst = append(dst, '\\', 't')
+		case r == '\v':
+			dst = append(dst, '\\', 'v')
+		case r == '\b':
+			dst = append(dst, '\\', 'b')
+		case r == '\f':
+			dst = append(dst, '\\', 'f')
+		case r == 0x1b:
+			dst = append(dst, '\\', 'e')
+		case r == utf8.RuneError && s[i] != 0xef:
+			dst = p.appendCodeEscape(dst, rune(s[i]))
+		case !unicode.IsPrint(r):
+			dst = p.appendCodeEscape(dst, r)
+		default:
+			dst = utf8.AppendRune(dst, r)
+		}
+	}
+	return append(dst, quote)
+}
+
+// appendCodeEscape writes r as a numeric escape: octal in Erlang, as
+// io_lib writes control characters, and hexadecimal in Elixir.
+func (p *Printer) appendCodeEscape(dst []byte, r rune) []byte {
+	switch {
+	case p.Elixir && r < 256:
+		return fmt.Appendf(dst, "\\x%02X", r)
+	case p.Elixir:
+		return fmt.Appendf(dst, "\\u{%X}", r)
+	case r < 256:
+		return fmt.Appendf(dst, "\\%03o", r)
+	default:
+		return fmt.Appendf(dst, "\\x{%X}", r)
+	}
+}
+
+// appendFloatText formats f the way Erlang prints floats: the shortest
+// digits that read back exactly, always with a fractional part, in
+// scientific notation only when that is shorter.
+func appendFloatText(dst []byte, f float64) []byte {
+	plain := strconv.FormatFloat(f, 'f', -1, 64)
+	if !strings.Contains(plain, ".") {
+		plain += ".0"
+	}
+
+	mant, exp, _ := strings.Cut(strconv.FormatFloat(f, 'e', -1, 64), "e")
+	if !strings.Contains(mant, ".") {
+		mant += ".0"
+	}
+	sign := ""
+	if exp[0] == '-' {
+		sign = "-"
+	}
+	exp = strings.TrimLeft(exp[1:], "0")
+	if exp == "" {
+		exp = "0"
+	}
+	sci := mant + "e" + sign + exp
+
+	if len(sci) < len(plain) {
+		return append(dst, sci...)
+	}
+	return append(dst, plain...)
+}
@@ -0,0 +1,145 @@
+package erlpack
+
+import (
+	"math"
+	"math/big"
+	"testing"
+)
+
+// printGolden pairs terms with the text io:format("~p") and Elixir's
+// inspect write for them.
+var printGolden = []struct {
+	term   Term
+	erlang string
+	elixir string
+}{
+	// Atoms.
+	{Atom("ok"), `ok`, `:ok`},
+	{Atom("Hello world"), `'Hello world'`, `:"Hello world"`},
+	{Atom("receive"), `'receive'`, `:receive`},
+	{Atom("nil"), `nil`, `nil`},
+	{Atom("true"), `true`, `true`},
+	{Atom("Elixir.Foo.Bar"), `'Elixir.Foo.Bar'`, `Foo.Bar`},
+	{Atom("valid?"), `'valid?'`, `:valid?`},
+	{Atom("it's"), `'it\'s'`, `:"it's"`},
+
+	// Numbers.
+	{Int(-42), `-42`, `-42`},
+	{BigInt{new(big.Int).Lsh(big.NewInt(1), 70)}, `1180591620717411303424`, `1180591620717411303424`},
+	{Float(1.5), `1.5`, `1.5`},
+	{Float(2), `2.0`, `2.0`},
+	{Float(math.Copysign(0, -1)), `-0.0`, `-0.0`},
+	{Float(0.1), `0.1`, `0.1`},
+	{Float(1e21), `1.0e21`, `1.0e21`},
+	{Float(123456789.0), `123456789.0`, `123456789.0`},
+	{Float(1.5e-7), `1.5e-7`, `1.5e-7`},
+	{Float(math.MaxFloat64), `1.7976931348623157e308`, `1.7976931348623157e308`},
+
+	// Binaries: text when valid UTF-8, bytes otherwise.
+	{Binary(""), `<<>>`, `<<>>`},
+	{Binary("hello"), `<<"hello">>`, `"hello"`},
+	{Binary("line\n\"q\""), `<<"line\n\"q\"">>`, `"line\n\"q\""`},
+	{Binary("日本"), `<<"日本"/utf8>>`, `"日本"`},
+	{Binary("#{x}"), `<<"#{x}">>`, `"\#{x}"`},
+	{Binary{0xff, 0x00, 0x41}, `<<255,0,65>>`, `<<255, 0, 65>>`},
+	{Binary("caf\xe9"), `<<99,97,102,233>>`, `<<99, 97, 102, 233>>`},
+	{Binary("a\x00b"), `<<97,0,98>>`, `<<97, 0, 98>>`},
+
+	// Charlists and lists.
+	{Charlist("abc"), `"abc"`, `~c"abc"`},
+	{Charlist("caf\xe9"), `"café"`, `[99, 97, 102, 233]`},
+	{Charlist{1, 2, 3}, `[1,2,3]`, `[1, 2, 3]`},
+	{Charlist(""), `[]`, `[]`},
+	{List{Elems: []Term{Int(104), Int(105)}}, `"hi"`, `~c"hi"`},
+	{List{Elems: []Term{Atom("a"), Binary("b")}}, `[a,<<"b">>]`, `[:a, "b"]`},
+	{List{}, `[]`, `[]`},
+
+	// Improper lists.
+	{List{Elems: []Term{Int(1)}, Tail: Int(2)}, `[1|2]`, `[1 | 2]`},
+	{List{Elems: []Term{Int(1), Int(2)}, Tail: Atom("t")}, `[1,2|t]`, `[1, 2 | :t]`},
+	{List{Elems: []Term{Int(104)}, Tail: Int(105)}, `[104|105]`, `[104 | 105]`},
+
+	// Tuples and maps, with members in the order given.
+	{Tuple{}, `{}`, `{}`},
+	{Tuple{Atom("ok"), Int(1)}, `{ok,1}`, `{:ok, 1}`},
+	{Map{{Key: Atom("b"), Value: Int(1)}, {Key: Atom("a"), Value: Int(2)}}, `#{b => 1,a => 2}`, `%{b: 1, a: 2}`},
+	{Map{{Key: Int(1), Value: Atom("x")}, {Key: Atom("a"), Value: Int(2)}}, `#{1 => x,a => 2}`, `%{1 => :x, :a => 2}`},
+	{Map{{Key: Binary("k"), Value: Charlist("v")}}, `#{<<"k">> => "v"}`, `%{"k" => ~c"v"}`},
+	{Map{}, `#{}`, `%{}`},
+
+	// Pids, ports, references and funs.
+	{Pid{Node: Atom("nonode@nohost"), ID: 80, Serial: 2}, `<0.80.2>`, `#PID<0.80.2>`},
+	{Port{Node: Atom("n"), ID: 5}, `#Port<0.5>`, `#Port<0.5>`},
+	{Ref{Node: Atom("n"), ID: []uint32{1, 2, 3}}, `#Ref<0.3.2.1>`, `#Reference<0.3.2.1>`},
+	{Export{Module: Atom("lists"), Function: Atom("map"), Arity: 2}, `fun lists:map/2`, `&:lists.map/2`},
+	{Export{Module: Atom("Elixir.Enum"), Function: Atom("map?"), Arity: 1}, `fun 'Elixir.Enum':'map?'/1`, `&Enum.map?/1`},
+	{Fun{Arity: 1, Index: 3, Module: Atom("erl_eval"), OldUniq: Int(12345)}, `#Fun<erl_eval.3.12345>`, `#Function<3.12345/1 in :erl_eval>`},
+
+	// Encoded terms print as the term they hold.
+	{Encoded{Term: Atom("ok"), Tag: ATOM_EXT}, `ok`, `:ok`},
+}
+
+func TestPrintGolden(t *testing.T) {
+	erlang, elixir := NewPrinter(), &Printer{Elixir: true}
+	for _, tt := range printGolden {
+		if out, err := erlang.AppendTerm(nil, tt.term); err != nil || string(out) != tt.erlang {
+			t.Errorf("Erlang %#v = %s, %v; want %s", tt.term, out, err, tt.erlang)
+		}
+		if out, err := elixir.AppendTerm(nil, tt.term); err != nil || string(out) != tt.elixir {
+			t.Errorf("Elixir %#v = %s, %v; want %s", tt.term, out, err, tt.elixir)
+		}
+	}
+}
+
+func TestFormatMapOrder(t *testing.T) {
+	// Map members print in the order of the frame, which for term_to_binary
+	// is term order.
+	frame, err := PackLiteral(`#{<<"z">> => 3, {t} => 4, b => 1, a => 2, 1 => x}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out, err := Format(frame); err != nil || out != `#{1 => x,a => 2,b => 1,{t} => 4,<<"z">> => 3}` {
+		t.Errorf("Format = %s, %v", out, err)
+	}
+}
+
+func TestPrintIndent(t *testing.T) {
+	term := Map{
+		{Key: Atom("op"), Value: Int(0)},
+		{Key: Atom("d"), Value: List{Elems: []Term{
+			Tuple{Atom("member"), Binary("alice"), Int(1)},
+			Tuple{Atom("member"), Binary("bob"), Int(2)},
+		}}},
+	}
+
+	for _, tt := range []struct {
+		p    Printer
+		want string
+	}{
+		{Printer{}, `#{op => 0,d => [{member,<<"alice">>,1},{member,<<"bob">>,2}]}`},
+		// Everything fits in the default width of 80.
+		{Printer{Indent: "  "}, `#{op => 0,d => [{member,<<"alice">>,1},{member,<<"bob">>,2}]}`},
+		{Printer{Indent: "  ", Width: 60}, "#{\n" +
+			"  op => 0,\n" +
+			"  d => [{member,<<\"alice\">>,1},{member,<<\"bob\">>,2}]\n" +
+			"}"},
+		{Printer{Indent: "  ", Width: 30}, "#{\n" +
+			"  op => 0,\n" +
+			"  d => [\n" +
+			"    {member,<<\"alice\">>,1},\n" +
+			"    {member,<<\"bob\">>,2}\n" +
+			"  ]\n" +
+			"}"},
+		{Printer{Indent: "\t", Width: 30, Elixir: true}, "%{\n" +
+			"\top: 0,\n" +
+			"\td: [\n" +
+			"\t\t{:member, \"alice\", 1},\n" +
+			"\t\t{:member, \"bob\", 2}\n" +
+			"\t]\n" +
+			"}"},
+	} {
+		if out, err := tt.p.AppendTerm(nil, term); err != nil || string(out) != tt.want {
+			t.Errorf("%+v:\n%s\nwant\n%s", tt.p, out, tt.want)
+		}
+	}
+}
@@ -0,0 +1,219 @@
+package erlpack
+
+import (
+	"io"
+	"math/big"
+)
+
+type Kind uint8
+
+const (
+	KindInvalid Kind = iota
+	KindMap
+	KindList
+	KindTuple
+	KindAtom
+	KindBinary
+	KindString
+	KindInt
+	KindFloat
+	KindBig
+	KindNil
+)
+
+var kindNames = [...]string{
+	KindInvalid: "invalid",
+	KindMap:     "map",
+	KindList:    "list",
+	KindTuple:   "tuple",
+	KindAtom:    "atom",
+	KindBinary:  "binary",
+	KindString:  "string",
+	KindInt:     "int",
+	KindFloat:   "float",
+	KindBig:     "big",
+	KindNil:     "nil",
+}
+
+func (k Kind) String() string {
+	if int(k) < len(kindNames) {
+		return kindNames[k]
+	}
+	return "invalid"
+}
+
+// Token is one step of a Reader walk. Headers carry the number of members
+// in Size: pairs for maps, elements for lists and tuples. A list header is
+// followed by its elements and then its tail, which is a KindNil token for
+// proper lists. Bytes aliases the input and holds the payload of atoms,
+// binaries and strings, or the little-endian magnitude of a bignum.
+type Token struct {
+	Kind  Kind
+	Tag   byte
+	Size  uint32
+	Int   int64
+	Float float64
+	Neg   bool
+	Bytes []byte
+}
+
+func (t Token) Big() *big.Int {
+	return newBigInt(t.Neg, t.Bytes)
+}
+
+type readerFrame struct {
+	data   []byte
+	offset int
+}
+
+// Reader is a pull parser over an ETF frame in the spirit of ei_decode:
+// it hands out one token at a time and leaves the structure to the caller.
+type Reader struct {
+	d      *Decoder
+	frames []readerFrame
+}
+
+// NewReader returns a Reader over data with the default limits.
+func NewReader(data []byte) (*Reader, error) {
+	return newScanDecoder().NewReader(data)
+}
+
+// NewReader returns a Reader over data that applies d's length and size
+// limits. MaxDepth only applies within Reader.Skip, since the Reader does
+// not track the nesting of the tokens it returns.
+func (d *Decoder) NewReader(data []byte) (*Reader, error) {
+	if err := d.begin(data); err != nil {
+		return nil, err
+	}
+
+	// Only a whole frame can be compressed; it is inflated up front and
+	// reading resumes after the compressed data once the term is done.
+	r := &Reader{d: d}
+	if len(d.data) > 0 && d.data[0] == COMPRESSED {
+		d.offset = 1
+		out, end, err := d.inflate()
+		if err != nil {
+			return nil, d.fail(err, COMPRESSED, tagNames[COMPRESSED])
+		}
+		r.frames = append(r.frames, readerFrame{data: d.data, offset: end})
+		d.data, d.offset = out, 0
+	}
+	return r, nil
+}
+
+// Offset returns the position of the next token in the frame. In a
+// COMPRESSED frame it counts into the inflated term.
+func (r *Reader) Offset() int {
+	return r.d.offset + 1
+}
+
+// Skip steps over the next term, including everything nested inside it.
+func (r *Reader) Skip() error {
+	if err := r.pop(); err != nil {
+		return err
+	}
+	return r.d.skip()
+}
+
+// pop returns to the enclosing data once a COMPRESSED term is exhausted,
+// and reports io.EOF once the whole frame has been read.
+func (r *Reader) pop() error {
+	for r.d.offset >= len(r.d.data) {
+		if len(r.frames) == 0 {
+			return io.EOF
+		}
+		f := r.frames[len(r.frames)-1]
+		r.frames = r.frames[:len(r.frames)-1]
+		r.d.data, r.d.offset = f.data, f.offset
+	}
+	return nil
+}
+
+func (r *Reader) Next() (Token, error) {
+	if err := r.pop(); err != nil {
+		return Token{}, err
+	}
+
+	d := r.d
+	tag, err := d.read8()
+	if err != nil {
+		return Token{}, d.fail(err, 0, "tag")
+	}
+
+	t, err := r.next(tag)
+	if err == ErrUnsupportedTag {
+		d.offset--
+		return Token{}, d.fail(err, tag, "term")
+	}
+	if err != nil {
+		return Token{}, d.fail(err, tag, tagNames[tag])
+	}
+	return t, nil
+}
+
+func (r *Reader) next(tag byte) (Token, error) {
+	d := r.d
+	t := Token{Tag: tag}
+
+	var err error
+	switch tag {
+	case SMALL_INTEGER_EXT:
+		var v uint8
+		v, err = d.read8()
+		t.Kind, t.Int = KindInt, int64(v)
+	case INTEGER_EXT:
+		var v uint32
+		v, err = d.read32()
+		t.Kind, t.Int = KindInt, int64(int32(v))
+	case NEW_FLOAT_EXT:
+		t.Kind = KindFloat
+		t.Float, err = d.readFloat()
+	case ATOM_EXT, ATOM_UTF8_EXT:
+		t.Kind = KindAtom
+		t.Bytes, err = d.readSized(2)
+	case SMALL_ATOM_EXT, SMALL_ATOM_UTF8_EXT:
+		t.Kind = KindAtom
+		t.Bytes, err = d.readSized(1)
+	case STRING_EXT:
+		t.Kind = KindString
+		t.Bytes, err = d.readSized(2)
+	case BINARY_EXT:
+		t.Kind = KindBinary
+		t.Bytes, err = d.readSized(4)
+	case SMALL_BIG_EXT, LARGE_BIG_EXT:
+		width := 1
+		if tag == LARGE_BIG_EXT {
+			width = 4
+		}
+		t.Kind = KindBig
+		t.Neg, t.Bytes, err = d.decodeBigRaw(width)
+	case NIL_EXT:
+		t.Kind = KindNil
+	case SMALL_TUPLE_EXT:
+		t.Kind = KindTuple
+		t.Size, err = r.header(1, 1)
+	case LARGE_TUPLE_EXT:
+		t.Kind = KindTuple
+		t.Size, err = r.header(4, 1)
+	case LIST_EXT:
+		t.Kind = KindList
+		t.Size, err = r.header(4, 1)
+	case MAP_EXT:
+		t.Kind = KindMap
+		t.Size, err = r.header(4, 2)
+	case COMPRESSED:
+		err = ErrNestedCompressed
+	default:
+		err = ErrUnsupportedTag
+	}
+
+	return t, err
+}
+
+func (r *Reader) header(width, size int) (uint32, error) {
+	n, err := r.d.readLength(width)
+	if err != nil {
+		return 0, err
+	}
+	return n, r.d.checkLength(n, size)
+}
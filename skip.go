@@ -0,0 +1,151 @@
+package erlpack
+
+import (
+	"errors"
+	"strconv"
+)
+
+var ErrTrailingData = errors.New("trailing data after term")
+
+// Validate reports whether data is exactly one well-formed ETF term,
+// checking it against the default limits without producing any output.
+// Neither Validate nor Skip allocates, except to inflate a COMPRESSED
+// frame or to report an error.
+func Validate(data []byte) error {
+	return newScanDecoder().Validate(data)
+}
+
+// Skip walks the term at the start of data and returns the number of bytes
+// it occupies, version byte included, so that data[n:] starts the next
+// frame.
+func Skip(data []byte) (int, error) {
+	return newScanDecoder().Skip(data)
+}
+
+func (d *Decoder) Validate(data []byte) error {
+	n, err := d.Skip(data)
+	if err != nil {
+		return err
+	}
+	if n != len(data) {
+		return d.fail(ErrTrailingData, data[n], "end of input")
+	}
+	return nil
+}
+
+func (d *Decoder) Skip(data []byte) (int, error) {
+	if err := d.begin(data); err != nil {
+		return 0, err
+	}
+	if err := d.decodeFrame(d.skip); err != nil {
+		return 0, err
+	}
+	return d.offset + 1, nil
+}
+
+// skip walks over one term using the same tag table and limits as decode
+// without producing any output.
+func (d *Decoder) skip() error {
+	start := d.offset
+	tag, err := d.read8()
+	if err != nil {
+		return d.fail(err, 0, "tag")
+	}
+
+	switch tag {
+	case SMALL_INTEGER_EXT:
+		_, err = d.read8()
+	case INTEGER_EXT:
+		_, err = d.read32()
+	case NEW_FLOAT_EXT:
+		_, err = d.readFloat()
+	case ATOM_EXT, ATOM_UTF8_EXT, STRING_EXT:
+		_, err = d.readSized(2)
+	case SMALL_ATOM_EXT, SMALL_ATOM_UTF8_EXT:
+		_, err = d.readSized(1)
+	case BINARY_EXT:
+		_, err = d.readSized(4)
+	case SMALL_BIG_EXT:
+		_, _, err = d.decodeBigRaw(1)
+	case LARGE_BIG_EXT:
+		_, _, err = d.decodeBigRaw(4)
+	case NIL_EXT:
+	case SMALL_TUPLE_EXT:
+		err = d.skipArray(1)
+	case LARGE_TUPLE_EXT:
+		err = d.skipArray(4)
+	case LIST_EXT:
+		if err = d.skipArray(4); err == nil {
+			if tail, terr := d.read8(); terr != nil || tail != NIL_EXT {
+				return d.fail(ErrListTailMissing, tail, "list tail")
+			}
+		}
+	case MAP_EXT:
+		err = d.skipMap()
+	case COMPRESSED:
+		err = ErrNestedCompressed
+	default:
+		d.offset = start
+		return d.fail(ErrUnsupportedTag, tag, "term")
+	}
+
+	if err != nil {
+		return d.fail(err, tag, tagNames[tag])
+	}
+	return nil
+}
+
+func (d *Decoder) skipArray(width int) error {
+	n, err := d.readLength(width)
+	if err != nil {
+		return err
+	}
+	if err := d.enter(n, 1); err != nil {
+		return err
+	}
+	for i := range n {
+		if err := d.skip(); err != nil {
+			return decodePath(err, strconv.FormatUint(uint64(i), 10))
+		}
+	}
+	d.leave()
+	return nil
+}
+
+func (d *Decoder) skipMap() error {
+	n, err := d.readLength(4)
+	if err != nil {
+		return err
+	}
+	if err := d.enter(n, 2); err != nil {
+		return err
+	}
+	for range n {
+		keyOff := d.offset
+		if err := d.skipKey(); err != nil {
+			return d.failKey(err, keyOff)
+		}
+		if err := d.skip(); err != nil {
+			return decodePath(err, d.keyAt(keyOff))
+		}
+	}
+	d.leave()
+	return nil
+}
+
+// skipKey accepts the same key tags as decodeKey without rendering the key.
+func (d *Decoder) skipKey() error {
+	tag, err := d.read8()
+	if err != nil {
+		return err
+	}
+
+	switch tag {
+	case ATOM_EXT, ATOM_UTF8_EXT, STRING_EXT, SMALL_ATOM_EXT, SMALL_ATOM_UTF8_EXT, BINARY_EXT,
+		SMALL_INTEGER_EXT, SMALL_BIG_EXT, LARGE_BIG_EXT, SMALL_TUPLE_EXT, LARGE_TUPLE_EXT:
+		d.offset--
+		return d.skip()
+	default:
+		return ErrUnsupportedKeyTag
+	}
+}
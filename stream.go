@@ -0,0 +1,281 @@
+package erlpack
+
+import (
+	"bufio"
+	"compress/zlib"
+	"encoding/binary"
+	"io"
+	"slices"
+)
+
+const streamChunk = 4 * 1024
+
+type StreamEncoder struct {
+	Encoder *Encoder
+
+	w   io.Writer
+	buf []byte
+}
+
+func NewStreamEncoder(w io.Writer) *StreamEncoder {
+	return &StreamEncoder{
+		Encoder: NewEncoder(),
+		w:       w,
+		buf:     make([]byte, 0, streamChunk),
+	}
+}
+
+// Encode writes the version byte and the encoded value to the underlying
+// writer, flushing whenever the internal buffer fills up. If an error is
+// returned part of the term may already have been written.
+func (s *StreamEncoder) Encode(v any) error {
+	enc := *s.Encoder
+	// A compressed term needs its full size up front, so it cannot be
+	// written out incrementally.
+	if enc.CompressThreshold <= 0 {
+		enc.w = s.w
+	}
+
+	buf, err := enc.appendPack(s.buf[:0], v)
+	if err != nil {
+		return err
+	}
+
+	if len(buf) > 0 {
+		_, err = s.w.Write(buf)
+	}
+	s.buf = buf[:0]
+
+	return err
+}
+
+// spill flushes dst to the stream writer once it has grown past
+// streamChunk. It is a no-op for in-memory encoding.
+func (e *Encoder) spill(dst []byte) ([]byte, error) {
+	if e.w == nil || len(dst) < streamChunk {
+		return dst, nil
+	}
+	if _, err := e.w.Write(dst); err != nil {
+		return nil, err
+	}
+	return dst[:0], nil
+}
+
+type StreamDecoder struct {
+	Decoder *Decoder
+
+	r    *bufio.Reader
+	term []byte
+}
+
+func NewStreamDecoder(r io.Reader) *StreamDecoder {
+	return &StreamDecoder{
+		Decoder: newScanDecoder(),
+		r:       bufio.NewReader(r),
+		term:    make([]byte, 0, streamChunk),
+	}
+}
+
+// Decode reads the next term and returns it as JSON. The result is only
+// valid until the next call.
+func (s *StreamDecoder) Decode() ([]byte, error) {
+	if err := s.readTerm(); err != nil {
+		return nil, err
+	}
+	return s.Decoder.Unpack(s.term)
+}
+
+func (s *StreamDecoder) DecodeValue() (any, error) {
+	if err := s.readTerm(); err != nil {
+		return nil, err
+	}
+	return s.Decoder.UnpackValue(s.term)
+}
+
+func (s *StreamDecoder) Unmarshal(v any) error {
+	if err := s.readTerm(); err != nil {
+		return err
+	}
+	return s.Decoder.Unmarshal(s.term, v)
+}
+
+// readTerm buffers exactly one version-prefixed term. It returns io.EOF
+// when the stream ends cleanly between terms and io.ErrUnexpectedEOF when
+// it ends inside one.
+func (s *StreamDecoder) readTerm() error {
+	s.term = s.term[:0]
+
+	version, err := s.r.ReadByte()
+	if err != nil {
+		return err
+	}
+	if version != FORMAT_VERSION {
+		return ErrInvalidFormat
+	}
+	s.term = append(s.term, version)
+
+	return s.scan(0)
+}
+
+func (s *StreamDecoder) copyN(n uint32) ([]byte, error) {
+	start := len(s.term)
+	for remaining := int(n); remaining > 0; {
+		chunk := min(remaining, streamChunk)
+		s.term = slices.Grow(s.term, chunk)
+		end := len(s.term) + chunk
+		if _, err := io.ReadFull(s.r, s.term[len(s.term):end]); err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return nil, err
+		}
+		s.term = s.term[:end]
+		remaining -= chunk
+	}
+	return s.term[start:], nil
+}
+
+func (s *StreamDecoder) copyLength(width int) (uint32, error) {
+	b, err := s.copyN(uint32(width))
+	if err != nil {
+		return 0, err
+	}
+	switch width {
+	case 1:
+		return uint32(b[0]), nil
+	case 2:
+		return uint32(binary.BigEndian.Uint16(b)), nil
+	default:
+		return binary.BigEndian.Uint32(b), nil
+	}
+}
+
+// copyBinary copies a length-prefixed payload, enforcing MaxBinarySize
+// before anything is buffered.
+func (s *StreamDecoder) copyBinary(width int) error {
+	l, err := s.copyLength(width)
+	if err != nil {
+		return err
+	}
+	if limit := s.Decoder.MaxBinarySize; limit > 0 && uint64(l) > uint64(limit) {
+		return ErrMaxBinarySize
+	}
+	_, err = s.copyN(l)
+	return err
+}
+
+func (s *StreamDecoder) scanN(width int, pairs bool, depth int) error {
+	n, err := s.copyLength(width)
+	if err != nil {
+		return err
+	}
+	if limit := s.Decoder.MaxDepth; limit > 0 && depth >= limit {
+		return ErrMaxDepth
+	}
+	if limit := s.Decoder.MaxElements; limit > 0 && uint64(n) > uint64(limit) {
+		return ErrMaxElements
+	}
+	count := uint64(n)
+	if pairs {
+		count *= 2
+	}
+	for range count {
+		if err := s.scan(depth + 1); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *StreamDecoder) scan(depth int) error {
+	b, err := s.copyN(1)
+	if err != nil {
+		return err
+	}
+
+	switch b[0] {
+	case SMALL_INTEGER_EXT:
+		_, err = s.copyN(1)
+	case INTEGER_EXT:
+		_, err = s.copyN(4)
+	case NEW_FLOAT_EXT:
+		_, err = s.copyN(8)
+	case NIL_EXT:
+	case ATOM_EXT, ATOM_UTF8_EXT, STRING_EXT:
+		err = s.copyBinary(2)
+	case SMALL_ATOM_EXT, SMALL_ATOM_UTF8_EXT:
+		err = s.copyBinary(1)
+	case BINARY_EXT:
+		err = s.copyBinary(4)
+	case SMALL_BIG_EXT, LARGE_BIG_EXT:
+		width := 1
+		if b[0] == LARGE_BIG_EXT {
+			width = 4
+		}
+		var l uint32
+		if l, err = s.copyLength(width); err == nil {
+			if limit := s.Decoder.MaxBinarySize; limit > 0 && uint64(l) > uint64(limit) {
+				return ErrMaxBinarySize
+			}
+			if _, err = s.copyN(1); err == nil {
+				_, err = s.copyN(l)
+			}
+		}
+	case SMALL_TUPLE_EXT:
+		err = s.scanN(1, false, depth)
+	case LARGE_TUPLE_EXT:
+		err = s.scanN(4, false, depth)
+	case LIST_EXT:
+		if err = s.scanN(4, false, depth); err == nil {
+			err = s.scan(depth + 1)
+		}
+	case MAP_EXT:
+		err = s.scanN(4, true, depth)
+	case COMPRESSED:
+		if depth > 0 {
+			return ErrNestedCompressed
+		}
+		err = s.inflate()
+	default:
+		err = ErrUnsupportedTag
+	}
+
+	return err
+}
+
+// inflate replaces a COMPRESSED term in the buffer with the term it wraps.
+// The zlib reader consumes the bufio.Reader byte by byte, so nothing past
+// the end of the compressed data is read.
+func (s *StreamDecoder) inflate() error {
+	start := len(s.term) - 1
+
+	size, err := s.copyLength(4)
+	if err != nil {
+		return err
+	}
+	if limit := s.Decoder.MaxInflateSize; limit > 0 && size > uint32(limit) {
+		return ErrInflateTooLarge
+	}
+	s.term = s.term[:start]
+
+	zr, err := zlib.NewReader(s.r)
+	if err != nil {
+		return noEOF(err)
+	}
+	defer zr.Close()
+
+	out, err := inflateExact(zr, size)
+	if err != nil {
+		return noEOF(err)
+	}
+	s.term = append(s.term, out...)
+
+	return nil
+}
+
+func noEOF(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
@@ -0,0 +1,123 @@
+package erlpack
+
+import (
+	"bytes"
+	"errors"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// countingWriter records how many writes it received, failing from the
+// failAt-th write onwards when failAt is positive.
+type countingWriter struct {
+	bytes.Buffer
+	writes int
+	failAt int
+}
+
+var errWrite = errors.New("write failed")
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	w.writes++
+	if w.failAt > 0 && w.writes >= w.failAt {
+		return 0, errWrite
+	}
+	return w.Buffer.Write(p)
+}
+
+type streamStruct struct {
+	Name  string   `json:"name"`
+	Items []string `json:"items"`
+	Count int      `json:"count"`
+}
+
+// largeValues are larger than the stream buffer, so encoding them spills
+// to the writer more than once.
+func largeValues() map[string]any {
+	list := make([]any, 2000)
+	items := make([]string, 2000)
+	m := make(map[string]any, 2000)
+	for i := range list {
+		s := "item " + strconv.Itoa(i)
+		list[i], items[i], m[s] = s, s, i
+	}
+	return map[string]any{
+		"list":   list,
+		"map":    m,
+		"struct": streamStruct{Name: "large", Items: items, Count: len(items)},
+	}
+}
+
+func TestStreamEncoder(t *testing.T) {
+	for name, v := range largeValues() {
+		var w countingWriter
+		s := NewStreamEncoder(&w)
+		if err := s.Encode(v); err != nil {
+			t.Errorf("%s: Encode: %v", name, err)
+			continue
+		}
+		if w.writes < 2 {
+			t.Errorf("%s: %d writes for %d bytes, want the buffer spilled", name, w.writes, w.Len())
+		}
+
+		want := NewEncoder().Pack(v)
+		if name == "map" {
+			// Map entries are written in iteration order, which changes
+			// from one encoding to the next.
+			got, err := NewDecoder().UnpackValue(w.Bytes())
+			if exp, _ := NewDecoder().UnpackValue(want); err != nil || w.Len() != len(want) || !reflect.DeepEqual(got, exp) {
+				t.Errorf("%s: Encode wrote %d bytes, %v; want the %d bytes of Pack", name, w.Len(), err, len(want))
+			}
+			continue
+		}
+		if !bytes.Equal(w.Bytes(), want) {
+			t.Errorf("%s: Encode wrote %d bytes that differ from Pack's %d", name, w.Len(), len(want))
+		}
+
+		// The buffer is reused by the next term.
+		w.Reset()
+		if err := s.Encode(Atom("next")); err != nil || !bytes.Equal(w.Bytes(), NewEncoder().Pack(Atom("next"))) {
+			t.Errorf("%s: second Encode wrote % x, %v", name, w.Bytes(), err)
+		}
+	}
+}
+
+func TestStreamEncoderCompressed(t *testing.T) {
+	v := largeValues()["list"]
+
+	var w countingWriter
+	s := NewStreamEncoder(&w)
+	s.Encoder.CompressThreshold = 64
+	if err := s.Encode(v); err != nil {
+		t.Fatal(err)
+	}
+
+	// A compressed term is encoded in memory and written at once.
+	e := NewEncoder()
+	e.CompressThreshold = 64
+	if want := e.Pack(v); w.writes != 1 || !bytes.Equal(w.Bytes(), want) {
+		t.Errorf("Encode made %d writes of % x, want one of % x", w.writes, w.Bytes()[:8], want[:8])
+	}
+	if w.Bytes()[1] != COMPRESSED {
+		t.Errorf("Encode did not compress: % x", w.Bytes()[:8])
+	}
+}
+
+func TestStreamEncoderWriteError(t *testing.T) {
+	for _, failAt := range []int{1, 2} {
+		for name, v := range largeValues() {
+			w := countingWriter{failAt: failAt}
+			if err := NewStreamEncoder(&w).Encode(v); !errors.Is(err, errWrite) {
+				t.Errorf("%s: Encode with write %d failing = %v, want errWrite", name, failAt, err)
+			}
+		}
+	}
+
+	// A term that fits the buffer fails on its only write.
+	w := countingWriter{failAt: 1}
+	if err := NewStreamEncoder(&w).Encode(strings.Repeat("x", 10)); !errors.Is(err, errWrite) {
+		t.Errorf("Encode = %v, want errWrite", err)
+	}
+}
@@ -9,6 +9,7 @@ import (
 	"fmt"
 	"reflect"
 	"slices"
+	"strconv"
 	"strings"
 )
 
@@ -87,8 +88,10 @@ func (s *Struct) FillMap(out map[string]any) {
 		isSubStruct := false
 		var finalVal any
 
+		// A nil pointer is encoded as nil rather than handed to a
+		// MarshalJSON method that may not accept it.
 		if val.CanInterface() {
-			if marshaler, ok := val.Interface().(json.Marshaler); ok {
+			if marshaler, ok := val.Interface().(json.Marshaler); ok && !isNilValue(reflect.ValueOf(marshaler)) && marshalsJSON(reflect.TypeOf(marshaler)) {
 				if b, err := marshaler.MarshalJSON(); err == nil {
 					var temp any
 					if err := json.Unmarshal(b, &temp); err == nil {
@@ -103,7 +106,11 @@ func (s *Struct) FillMap(out map[string]any) {
 			name = tagName
 		}
 
+		// val is invalid when MarshalJSON returned null, which is empty.
 		if tagOpts.Has("omitempty") {
+			if !val.IsValid() {
+				continue
+			}
 			zero := reflect.Zero(val.Type()).Interface()
 			current := val.Interface()
 
@@ -113,13 +120,13 @@ func (s *Struct) FillMap(out map[string]any) {
 		}
 
 		if tagOpts.Has("omitzero") {
-			if s.isZeroValue(val) {
+			if isZeroValue(val) {
 				continue
 			}
 		}
 
 		if !tagOpts.Has("omitnested") {
-			if s.isNilValue(val) {
+			if isNilValue(val) {
 				finalVal = nil
 			} else {
 				finalVal = s.nested(val)
@@ -133,21 +140,22 @@ func (s *Struct) FillMap(out map[string]any) {
 					isSubStruct = true
 				}
 			}
-		} else {
+		} else if val.IsValid() {
 			finalVal = val.Interface()
 		}
 
 		if tagOpts.Has("string") {
-			s, ok := val.Interface().(fmt.Stringer)
-			if ok {
-				out[name] = s.String()
+			if s, ok := quoteField(val); ok {
+				out[name] = s
+				continue
 			}
-			continue
 		}
 
 		if isSubStruct && tagOpts.Has("flatten") || (s.Flattern && field.Anonymous && field.Type.Kind() == reflect.Struct && len(tagOpts) == 0) {
-			for k := range finalVal.(map[string]any) {
-				out[k] = finalVal.(map[string]any)[k]
+			if m, ok := finalVal.(map[string]any); ok {
+				for k := range m {
+					out[k] = m[k]
+				}
 			}
 		} else {
 			out[name] = finalVal
@@ -155,7 +163,40 @@ func (s *Struct) FillMap(out map[string]any) {
 	}
 }
 
-func (s *Struct) isZeroValue(v reflect.Value) bool {
+// quoteField returns the text a field tagged ",string" is encoded as: the
+// String method of a fmt.Stringer, or the formatted value of a string,
+// bool or number, which Unmarshal parses back. Other values, and nil
+// pointers, are encoded as if the option were absent.
+func quoteField(v reflect.Value) (string, bool) {
+	if isNilValue(v) || isNilValue(reflect.ValueOf(v.Interface())) {
+		return "", false
+	}
+	if s, ok := v.Interface().(fmt.Stringer); ok {
+		return s.String(), true
+	}
+
+	for v.Kind() == reflect.Pointer || v.Kind() == reflect.Interface {
+		if v = v.Elem(); isNilValue(v) {
+			return "", false
+		}
+	}
+
+	switch v.Kind() {
+	case reflect.String:
+		return v.String(), true
+	case reflect.Bool:
+		return strconv.FormatBool(v.Bool()), true
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return strconv.FormatInt(v.Int(), 10), true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return strconv.FormatUint(v.Uint(), 10), true
+	case reflect.Float32, reflect.Float64:
+		return strconv.FormatFloat(v.Float(), 'g', -1, v.Type().Bits()), true
+	}
+	return "", false
+}
+
+func isZeroValue(v reflect.Value) bool {
 	if !v.IsValid() {
 		return true
 	}
@@ -181,18 +222,18 @@ func (s *Struct) isZeroValue(v reflect.Value) bool {
 		if v.IsNil() {
 			return true
 		}
-		return s.isZeroValue(v.Elem())
+		return isZeroValue(v.Elem())
 	case reflect.Struct:
 		for i := range v.NumField() {
 			field := v.Field(i)
-			if !s.isZeroValue(field) {
+			if !isZeroValue(field) {
 				return false
 			}
 		}
 		return true
 	case reflect.Array, reflect.Slice:
 		for i := range v.Len() {
-			if !s.isZeroValue(v.Index(i)) {
+			if !isZeroValue(v.Index(i)) {
 				return false
 			}
 		}
@@ -204,7 +245,7 @@ func (s *Struct) isZeroValue(v reflect.Value) bool {
 	}
 }
 
-func (*Struct) isNilValue(v reflect.Value) bool {
+func isNilValue(v reflect.Value) bool {
 	if !v.IsValid() {
 		return true
 	}
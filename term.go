@@ -0,0 +1,851 @@
+package erlpack
+
+import (
+	"encoding/binary"
+	"math"
+	"math/big"
+	"slices"
+	"strconv"
+)
+
+// termEncoder encodes terms with UTF-8 atom tags and no stream writer.
+var termEncoder = &Encoder{}
+
+func appendTermBytes(t Term, dst []byte) []byte {
+	dst, err := t.appendTerm(termEncoder, dst)
+	if err != nil {
+		panic(err)
+	}
+	return dst
+}
+
+func (a Atom) Append(dst []byte) []byte     { return appendTermBytes(a, dst) }
+func (t Tuple) Append(dst []byte) []byte    { return appendTermBytes(t, dst) }
+func (b Binary) Append(dst []byte) []byte   { return appendTermBytes(b, dst) }
+func (c Charlist) Append(dst []byte) []byte { return appendTermBytes(c, dst) }
+func (i Int) Append(dst []byte) []byte      { return appendTermBytes(i, dst) }
+func (b BigInt) Append(dst []byte) []byte   { return appendTermBytes(b, dst) }
+func (f Float) Append(dst []byte) []byte    { return appendTermBytes(f, dst) }
+func (l List) Append(dst []byte) []byte     { return appendTermBytes(l, dst) }
+func (m Map) Append(dst []byte) []byte      { return appendTermBytes(m, dst) }
+func (p Pid) Append(dst []byte) []byte      { return appendTermBytes(p, dst) }
+func (p Port) Append(dst []byte) []byte     { return appendTermBytes(p, dst) }
+func (r Ref) Append(dst []byte) []byte      { return appendTermBytes(r, dst) }
+func (f Fun) Append(dst []byte) []byte      { return appendTermBytes(f, dst) }
+func (x Export) Append(dst []byte) []byte   { return appendTermBytes(x, dst) }
+func (x Encoded) Append(dst []byte) []byte  { return appendTermBytes(x, dst) }
+
+func (a Atom) appendTerm(e *Encoder, dst []byte) ([]byte, error) {
+	return e.appendAtom(dst, a)
+}
+
+func (t Tuple) appendTerm(e *Encoder, dst []byte) ([]byte, error) {
+	return e.appendTuple(dst, t)
+}
+
+func (b Binary) appendTerm(e *Encoder, dst []byte) ([]byte, error) {
+	if len(b) > math.MaxUint32 {
+		return nil, encodeError(ErrTooLarge, b)
+	}
+	dst = append(dst, BINARY_EXT)
+	dst = e.AppendUint32(dst, uint32(len(b)))
+	return append(dst, b...), nil
+}
+
+// appendTerm uses STRING_EXT where it can, falling back to a list of small
+// integers for charlists longer than its 16-bit length allows.
+func (c Charlist) appendTerm(e *Encoder, dst []byte) ([]byte, error) {
+	switch {
+	case len(c) == 0:
+		return append(dst, NIL_EXT), nil
+	case len(c) <= math.MaxUint16:
+		dst = append(dst, STRING_EXT)
+		dst = e.AppendUint16(dst, uint16(len(c)))
+		return append(dst, c...), nil
+	case len(c) > math.MaxUint32-1:
+		return nil, encodeError(ErrTooLarge, c)
+	}
+
+	dst = append(dst, LIST_EXT)
+	dst = e.AppendUint32(dst, uint32(len(c)))
+	for _, b := range c {
+		dst = append(dst, SMALL_INTEGER_EXT, b)
+	}
+	return append(dst, NIL_EXT), nil
+}
+
+func (i Int) appendTerm(e *Encoder, dst []byte) ([]byte, error) {
+	return e.AppendInt(dst, int64(i)), nil
+}
+
+func (b BigInt) appendTerm(e *Encoder, dst []byte) ([]byte, error) {
+	if b.Int == nil {
+		return e.AppendNil(dst), nil
+	}
+	return e.AppendBig(dst, b.Int), nil
+}
+
+func (f Float) appendTerm(e *Encoder, dst []byte) ([]byte, error) {
+	if !isFinite(float64(f)) {
+		return nil, encodeError(ErrInvalidFloat, f)
+	}
+	dst = append(dst, NEW_FLOAT_EXT)
+	return e.AppendFloat64(dst, float64(f)), nil
+}
+
+func (l List) appendTerm(e *Encoder, dst []byte) ([]byte, error) {
+	if len(l.Elems) == 0 && l.Tail == nil {
+		return append(dst, NIL_EXT), nil
+	}
+	if len(l.Elems) > math.MaxUint32-1 {
+		return nil, encodeError(ErrTooLarge, l)
+	}
+
+	dst = append(dst, LIST_EXT)
+	dst = e.AppendUint32(dst, uint32(len(l.Elems)))
+	for i := range l.Elems {
+		var err error
+		if dst, err = e.appendTermValue(dst, l.Elems[i]); err != nil {
+			return nil, withIndex(err, i)
+		}
+		if dst, err = e.spill(dst); err != nil {
+			return nil, err
+		}
+	}
+
+	if l.Tail == nil {
+		return append(dst, NIL_EXT), nil
+	}
+	dst, err := l.Tail.appendTerm(e, dst)
+	if err != nil {
+		return nil, withPath(err, "tail")
+	}
+	return dst, nil
+}
+
+func (m Map) appendTerm(e *Encoder, dst []byte) ([]byte, error) {
+	if len(m) > math.MaxUint32-1 {
+		return nil, encodeError(ErrTooLarge, m)
+	}
+
+	dst = append(dst, MAP_EXT)
+	dst = e.AppendUint32(dst, uint32(len(m)))
+	for i := range m {
+		var err error
+		if dst, err = e.appendTermValue(dst, m[i].Key); err != nil {
+			return nil, withIndex(err, i)
+		}
+		if dst, err = e.appendTermValue(dst, m[i].Value); err != nil {
+			return nil, withIndex(err, i)
+		}
+		if dst, err = e.spill(dst); err != nil {
+			return nil, err
+		}
+	}
+	return dst, nil
+}
+
+// appendTermValue encodes a nil Term as the empty list, the same way a nil
+// list tail is encoded.
+func (e *Encoder) appendTermValue(dst []byte, t Term) ([]byte, error) {
+	if t == nil {
+		return append(dst, NIL_EXT), nil
+	}
+	return t.appendTerm(e, dst)
+}
+
+// appendField writes a term nested in a pid, port, reference or fun,
+// writing def in place of a nil t.
+func (e *Encoder) appendField(dst []byte, t, def Term) ([]byte, error) {
+	if t == nil {
+		t = def
+	}
+	return t.appendTerm(e, dst)
+}
+
+func (p Pid) appendTerm(e *Encoder, dst []byte) ([]byte, error) {
+	return p.appendTagged(e, dst, NEW_PID_EXT)
+}
+
+// appendTagged writes the pid as NEW_PID_EXT or PID_EXT, which has a one
+// byte creation.
+func (p Pid) appendTagged(e *Encoder, dst []byte, tag byte) ([]byte, error) {
+	switch {
+	case tag != NEW_PID_EXT && tag != PID_EXT:
+		return nil, encodeError(ErrInvalidEncoding, p)
+	case tag == PID_EXT && p.Creation > math.MaxUint8:
+		return nil, encodeError(ErrTooLarge, p)
+	}
+
+	dst = append(dst, tag)
+	dst, err := e.appendField(dst, p.Node, Atom(""))
+	if err != nil {
+		return nil, err
+	}
+	dst = e.AppendUint32(dst, p.ID)
+	dst = e.AppendUint32(dst, p.Serial)
+	if tag == PID_EXT {
+		return append(dst, byte(p.Creation)), nil
+	}
+	return e.AppendUint32(dst, p.Creation), nil
+}
+
+func (p Port) appendTerm(e *Encoder, dst []byte) ([]byte, error) {
+	if p.ID > math.MaxUint32 {
+		return p.appendTagged(e, dst, V4_PORT_EXT)
+	}
+	return p.appendTagged(e, dst, NEW_PORT_EXT)
+}
+
+// appendTagged writes the port as V4_PORT_EXT, NEW_PORT_EXT or PORT_EXT,
+// the last two having a 32-bit ID and PORT_EXT a one byte creation.
+func (p Port) appendTagged(e *Encoder, dst []byte, tag byte) ([]byte, error) {
+	switch {
+	case tag != V4_PORT_EXT && tag != NEW_PORT_EXT && tag != PORT_EXT:
+		return nil, encodeError(ErrInvalidEncoding, p)
+	case tag != V4_PORT_EXT && p.ID > math.MaxUint32,
+		tag == PORT_EXT && p.Creation > math.MaxUint8:
+		return nil, encodeError(ErrTooLarge, p)
+	}
+
+	dst = append(dst, tag)
+	dst, err := e.appendField(dst, p.Node, Atom(""))
+	if err != nil {
+		return nil, err
+	}
+	if tag == V4_PORT_EXT {
+		dst = binary.BigEndian.AppendUint64(dst, p.ID)
+	} else {
+		dst = e.AppendUint32(dst, uint32(p.ID))
+	}
+	if tag == PORT_EXT {
+		return append(dst, byte(p.Creation)), nil
+	}
+	return e.AppendUint32(dst, p.Creation), nil
+}
+
+func (r Ref) appendTerm(e *Encoder, dst []byte) ([]byte, error) {
+	return r.appendTagged(e, dst, NEWER_REFERENCE_EXT)
+}
+
+// appendTagged writes the reference as NEWER_REFERENCE_EXT or
+// NEW_REFERENCE_EXT, which has a one byte creation.
+func (r Ref) appendTagged(e *Encoder, dst []byte, tag byte) ([]byte, error) {
+	switch {
+	case tag != NEWER_REFERENCE_EXT && tag != NEW_REFERENCE_EXT:
+		return nil, encodeError(ErrInvalidEncoding, r)
+	case len(r.ID) > math.MaxUint16,
+		tag == NEW_REFERENCE_EXT && r.Creation > math.MaxUint8:
+		return nil, encodeError(ErrTooLarge, r)
+	}
+
+	dst = append(dst, tag)
+	dst = e.AppendUint16(dst, uint16(len(r.ID)))
+	dst, err := e.appendField(dst, r.Node, Atom(""))
+	if err != nil {
+		return nil, err
+	}
+	if tag == NEW_REFERENCE_EXT {
+		dst = append(dst, byte(r.Creation))
+	} else {
+		dst = e.AppendUint32(dst, r.Creation)
+	}
+	for _, id := range r.ID {
+		dst = e.AppendUint32(dst, id)
+	}
+	return dst, nil
+}
+
+// appendTerm back-patches the Size field once the free variables are
+// written, so the fun has to stay in dst until it is complete.
+func (f Fun) appendTerm(e *Encoder, dst []byte) ([]byte, error) {
+	if len(f.Free) > math.MaxUint32 {
+		return nil, encodeError(ErrTooLarge, f)
+	}
+
+	fe := *e
+	fe.w = nil
+
+	start := len(dst)
+	dst = append(dst, NEW_FUN_EXT, 0, 0, 0, 0, f.Arity)
+	dst = append(dst, f.Uniq[:]...)
+	dst = fe.AppendUint32(dst, f.Index)
+	dst = fe.AppendUint32(dst, uint32(len(f.Free)))
+
+	fields := [...]struct{ t, def Term }{
+		{f.Module, Atom("")},
+		{f.OldIndex, Int(0)},
+		{f.OldUniq, Int(0)},
+		{f.Pid, Pid{}},
+	}
+	var err error
+	for _, field := range fields {
+		if dst, err = fe.appendField(dst, field.t, field.def); err != nil {
+			return nil, err
+		}
+	}
+	for i := range f.Free {
+		if dst, err = fe.appendTermValue(dst, f.Free[i]); err != nil {
+			return nil, withIndex(err, i)
+		}
+	}
+
+	binary.BigEndian.PutUint32(dst[start+1:], uint32(len(dst)-start-1))
+	return dst, nil
+}
+
+func (x Export) appendTerm(e *Encoder, dst []byte) ([]byte, error) {
+	dst = append(dst, EXPORT_EXT)
+	dst, err := e.appendField(dst, x.Module, Atom(""))
+	if err != nil {
+		return nil, err
+	}
+	if dst, err = e.appendField(dst, x.Function, Atom("")); err != nil {
+		return nil, err
+	}
+	return append(dst, SMALL_INTEGER_EXT, x.Arity), nil
+}
+
+// appendTerm writes Term under Tag, failing with ErrInvalidEncoding when
+// the tag cannot hold a term of that type.
+func (x Encoded) appendTerm(e *Encoder, dst []byte) ([]byte, error) {
+	switch t := x.Term.(type) {
+	case Atom:
+		return x.appendAtom(e, dst, t)
+	case Int:
+		if x.Tag != INTEGER_EXT {
+			return x.appendBig(e, dst, big.NewInt(int64(t)))
+		}
+		if t < math.MinInt32 || t > math.MaxInt32 {
+			return nil, encodeError(ErrTooLarge, t)
+		}
+		dst = append(dst, INTEGER_EXT)
+		return e.AppendInt32(dst, int32(t)), nil
+	case BigInt:
+		if t.Int != nil {
+			return x.appendBig(e, dst, t.Int)
+		}
+	case Tuple:
+		if x.Tag != LARGE_TUPLE_EXT {
+			break
+		}
+		if len(t) > math.MaxUint32-1 {
+			return nil, encodeError(ErrTooLarge, t)
+		}
+		dst = append(dst, LARGE_TUPLE_EXT)
+		dst = e.AppendUint32(dst, uint32(len(t)))
+		return e.appendElems(dst, t)
+	case List:
+		if x.Tag == LIST_EXT && len(t.Elems) == 0 && t.Tail == nil {
+			return append(dst, LIST_EXT, 0, 0, 0, 0, NIL_EXT), nil
+		}
+	case Charlist:
+		if x.Tag != STRING_EXT {
+			break
+		}
+		if len(t) > math.MaxUint16 {
+			return nil, encodeError(ErrTooLarge, t)
+		}
+		dst = append(dst, STRING_EXT)
+		dst = e.AppendUint16(dst, uint16(len(t)))
+		return append(dst, t...), nil
+	case Pid:
+		return t.appendTagged(e, dst, x.Tag)
+	case Port:
+		return t.appendTagged(e, dst, x.Tag)
+	case Ref:
+		return t.appendTagged(e, dst, x.Tag)
+	}
+	return nil, encodeError(ErrInvalidEncoding, x.Term)
+}
+
+func (x Encoded) appendAtom(e *Encoder, dst []byte, a Atom) ([]byte, error) {
+	switch x.Tag {
+	case SMALL_ATOM_EXT, SMALL_ATOM_UTF8_EXT:
+		if len(a) > math.MaxUint8 {
+			return nil, encodeError(ErrTooLarge, a)
+		}
+		dst = append(dst, x.Tag, byte(len(a)))
+	case ATOM_EXT, ATOM_UTF8_EXT:
+		if len(a) > math.MaxUint16 {
+			return nil, encodeError(ErrTooLarge, a)
+		}
+		dst = append(dst, x.Tag)
+		dst = e.AppendUint16(dst, uint16(len(a)))
+	default:
+		return nil, encodeError(ErrInvalidEncoding, a)
+	}
+	return append(dst, a...), nil
+}
+
+// appendBig writes v as a SMALL_BIG_EXT or LARGE_BIG_EXT, padding its
+// magnitude with zero bytes up to Digits.
+func (x Encoded) appendBig(e *Encoder, dst []byte, v *big.Int) ([]byte, error) {
+	mag := v.Bytes()
+	digits := max(x.Digits, len(mag))
+
+	switch {
+	case x.Tag == SMALL_BIG_EXT && digits <= math.MaxUint8:
+		dst = append(dst, SMALL_BIG_EXT, byte(digits))
+	case x.Tag == LARGE_BIG_EXT && digits <= math.MaxUint32:
+		dst = append(dst, LARGE_BIG_EXT)
+		dst = e.AppendUint32(dst, uint32(digits))
+	case x.Tag == SMALL_BIG_EXT || x.Tag == LARGE_BIG_EXT:
+		return nil, encodeError(ErrTooLarge, x.Term)
+	default:
+		return nil, encodeError(ErrInvalidEncoding, x.Term)
+	}
+
+	var sign byte
+	if v.Sign() < 0 {
+		sign = 1
+	}
+	dst = append(dst, sign)
+	for i := len(mag) - 1; i >= 0; i-- {
+		dst = append(dst, mag[i])
+	}
+	for range digits - len(mag) {
+		dst = append(dst, 0)
+	}
+	return dst, nil
+}
+
+// unwrap returns the term an Encoded holds, or t itself.
+func unwrap(t Term) Term {
+	if x, ok := t.(Encoded); ok {
+		return x.Term
+	}
+	return t
+}
+
+// atomOf returns the atom t holds, possibly Encoded.
+func atomOf(t Term) (Atom, bool) {
+	a, ok := unwrap(t).(Atom)
+	return a, ok
+}
+
+// ParseTerm decodes data into a Term tree that keeps every distinction
+// Unpack flattens: atoms, binaries and charlists, tuples and lists,
+// improper tails, and map keys of any type.
+// Terms written under a tag other than the one Append would choose are
+// wrapped in Encoded, so appending the tree reproduces data exactly, with
+// two exceptions: a COMPRESSED frame comes back uncompressed, and a bignum
+// sign byte other than 0 or 1 is written as 1.
+func ParseTerm(data []byte) (Term, error) {
+	return newScanDecoder().ParseTerm(data)
+}
+
+func (d *Decoder) ParseTerm(data []byte) (Term, error) {
+	if err := d.begin(data); err != nil {
+		return nil, err
+	}
+
+	var t Term
+	err := d.decodeFrame(func() (err error) {
+		t, err = d.decodeTerm()
+		return err
+	})
+	return t, err
+}
+
+func (d *Decoder) decodeTerm() (Term, error) {
+	start := d.offset
+	tag, err := d.read8()
+	if err != nil {
+		return nil, d.fail(err, 0, "tag")
+	}
+
+	t, err := d.decodeTermTag(tag)
+	if err == ErrUnsupportedTag {
+		d.offset = start
+		return nil, d.fail(err, tag, "term")
+	}
+	if err != nil {
+		return nil, d.fail(err, tag, tagNames[tag])
+	}
+	return t, nil
+}
+
+func (d *Decoder) decodeTermTag(tag byte) (Term, error) {
+	switch tag {
+	case SMALL_INTEGER_EXT:
+		v, err := d.read8()
+		if err != nil {
+			return nil, err
+		}
+		return Int(v), nil
+	case INTEGER_EXT:
+		v, err := d.read32()
+		if err != nil {
+			return nil, err
+		}
+		i := Int(int32(v))
+		if i >= 0 && i <= math.MaxUint8 {
+			return Encoded{Term: i, Tag: INTEGER_EXT}, nil
+		}
+		return i, nil
+	case NEW_FLOAT_EXT:
+		f, err := d.readFloat()
+		if err != nil {
+			return nil, err
+		}
+		return Float(f), nil
+	case ATOM_EXT, ATOM_UTF8_EXT, SMALL_ATOM_EXT, SMALL_ATOM_UTF8_EXT:
+		return d.decodeTermAtom(tag)
+	case STRING_EXT:
+		b, err := d.readSized(2)
+		if err != nil {
+			return nil, err
+		}
+		if len(b) == 0 {
+			return Encoded{Term: Charlist{}, Tag: STRING_EXT}, nil
+		}
+		return Charlist(slices.Clone(b)), nil
+	case BINARY_EXT:
+		b, err := d.readSized(4)
+		if err != nil {
+			return nil, err
+		}
+		return Binary(slices.Clone(b)), nil
+	case LIST_EXT:
+		return d.decodeTermList()
+	case NIL_EXT:
+		return List{}, nil
+	case SMALL_TUPLE_EXT:
+		return d.decodeTermTuple(1)
+	case LARGE_TUPLE_EXT:
+		return d.decodeTermTuple(4)
+	case MAP_EXT:
+		return d.decodeTermMap()
+	case SMALL_BIG_EXT:
+		return d.decodeTermBig(1)
+	case LARGE_BIG_EXT:
+		return d.decodeTermBig(4)
+	case NEW_PID_EXT:
+		return d.decodePid(4)
+	case PID_EXT:
+		p, err := d.decodePid(1)
+		return encodedAs(p, tag), err
+	case NEW_PORT_EXT:
+		return d.decodePort(4, 4)
+	case V4_PORT_EXT:
+		p, err := d.decodePort(8, 4)
+		if err != nil || p.(Port).ID > math.MaxUint32 {
+			return p, err
+		}
+		return encodedAs(p, tag), nil
+	case PORT_EXT:
+		p, err := d.decodePort(4, 1)
+		return encodedAs(p, tag), err
+	case NEWER_REFERENCE_EXT:
+		return d.decodeRef(4)
+	case NEW_REFERENCE_EXT:
+		r, err := d.decodeRef(1)
+		return encodedAs(r, tag), err
+	case NEW_FUN_EXT:
+		return d.decodeFun()
+	case EXPORT_EXT:
+		return d.decodeExport()
+	case COMPRESSED:
+		return nil, ErrNestedCompressed
+	default:
+		return nil, ErrUnsupportedTag
+	}
+}
+
+// encodedAs wraps t, read under tag, in an Encoded, leaving a nil t from a
+// failed read alone.
+func encodedAs(t Term, tag byte) Term {
+	if t == nil {
+		return nil
+	}
+	return Encoded{Term: t, Tag: tag}
+}
+
+// decodeTermAtom wraps the atom in an Encoded unless it has the UTF-8 tag
+// Atom.Append chooses for its length.
+func (d *Decoder) decodeTermAtom(tag byte) (Term, error) {
+	width := 2
+	if tag == SMALL_ATOM_EXT || tag == SMALL_ATOM_UTF8_EXT {
+		width = 1
+	}
+	b, err := d.readSized(width)
+	if err != nil {
+		return nil, err
+	}
+
+	canonical := byte(SMALL_ATOM_UTF8_EXT)
+	if len(b) > math.MaxUint8 {
+		canonical = ATOM_UTF8_EXT
+	}
+	if tag != canonical {
+		return Encoded{Term: Atom(b), Tag: tag}, nil
+	}
+	return Atom(b), nil
+}
+
+func (d *Decoder) decodeTerms(n uint32) ([]Term, error) {
+	if err := d.enter(n, 1); err != nil {
+		return nil, err
+	}
+	out := make([]Term, 0, n)
+	for i := range n {
+		t, err := d.decodeTerm()
+		if err != nil {
+			return nil, decodePath(err, strconv.FormatUint(uint64(i), 10))
+		}
+		out = append(out, t)
+	}
+	d.leave()
+	return out, nil
+}
+
+func (d *Decoder) decodeTermList() (Term, error) {
+	n, err := d.readLength(4)
+	if err != nil {
+		return nil, err
+	}
+	elems, err := d.decodeTerms(n)
+	if err != nil {
+		return nil, err
+	}
+
+	if d.offset < len(d.data) && d.data[d.offset] == NIL_EXT {
+		d.offset++
+		if n == 0 {
+			return Encoded{Term: List{}, Tag: LIST_EXT}, nil
+		}
+		return List{Elems: elems}, nil
+	}
+	tail, err := d.decodeTerm()
+	if err != nil {
+		return nil, decodePath(err, "tail")
+	}
+	return List{Elems: elems, Tail: tail}, nil
+}
+
+func (d *Decoder) decodeTermTuple(width int) (Term, error) {
+	n, err := d.readLength(width)
+	if err != nil {
+		return nil, err
+	}
+	elems, err := d.decodeTerms(n)
+	if err != nil {
+		return nil, err
+	}
+	out := make(Tuple, len(elems))
+	for i, t := range elems {
+		out[i] = t
+	}
+	if width == 4 && n <= math.MaxUint8 {
+		return Encoded{Term: out, Tag: LARGE_TUPLE_EXT}, nil
+	}
+	return out, nil
+}
+
+func (d *Decoder) decodeTermMap() (Term, error) {
+	n, err := d.readLength(4)
+	if err != nil {
+		return nil, err
+	}
+	if err := d.enter(n, 2); err != nil {
+		return nil, err
+	}
+	out := make(Map, 0, n)
+	for i := range n {
+		k, err := d.decodeTerm()
+		if err != nil {
+			return nil, decodePath(err, strconv.FormatUint(uint64(i), 10))
+		}
+		v, err := d.decodeTerm()
+		if err != nil {
+			return nil, decodePath(err, strconv.FormatUint(uint64(i), 10))
+		}
+		out = append(out, Pair{Key: k, Value: v})
+	}
+	d.leave()
+	return out, nil
+}
+
+// decodeTermBig returns an Int when the bignum fits in 64 bits, which is
+// also how Int encodes such values. Bignums Append would not write the
+// same way, because the value fits in INTEGER_EXT or the magnitude has
+// leading zero bytes or the wrong tag for its length, are Encoded.
+func (d *Decoder) decodeTermBig(width int) (Term, error) {
+	tag := d.data[d.offset-1]
+	neg, mag, err := d.decodeBigRaw(width)
+	if err != nil {
+		return nil, err
+	}
+
+	t := Term(BigInt{newBigInt(neg, mag)})
+	if value, ok := bigUint64(mag); ok {
+		if !neg && value <= math.MaxInt64 {
+			t = Int(value)
+		}
+		if neg && value <= 1<<63 {
+			t = Int(-value)
+		}
+	}
+
+	minimal := len(mag)
+	for minimal > 0 && mag[minimal-1] == 0 {
+		minimal--
+	}
+	canonical := byte(SMALL_BIG_EXT)
+	if minimal > math.MaxUint8 {
+		canonical = LARGE_BIG_EXT
+	}
+	if i, ok := t.(Int); ok && i >= math.MinInt32 && i <= math.MaxInt32 ||
+		minimal != len(mag) || tag != canonical {
+		return Encoded{Term: t, Tag: tag, Digits: len(mag)}, nil
+	}
+	return t, nil
+}
+
+// decodeNode reads the atom naming the node a pid, port or reference
+// belongs to, or the module and function of a fun.
+func (d *Decoder) decodeNode() (Term, error) {
+	t, err := d.decodeTerm()
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := atomOf(t); !ok {
+		return nil, ErrInvalidFormat
+	}
+	return t, nil
+}
+
+func (d *Decoder) decodePid(creation int) (Term, error) {
+	var p Pid
+	var err error
+	if p.Node, err = d.decodeNode(); err != nil {
+		return nil, err
+	}
+	if p.ID, err = d.read32(); err != nil {
+		return nil, err
+	}
+	if p.Serial, err = d.read32(); err != nil {
+		return nil, err
+	}
+	if p.Creation, err = d.readLength(creation); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+func (d *Decoder) decodePort(id, creation int) (Term, error) {
+	var p Port
+	var err error
+	if p.Node, err = d.decodeNode(); err != nil {
+		return nil, err
+	}
+	if id == 8 {
+		p.ID, err = d.read64()
+	} else {
+		var v uint32
+		v, err = d.read32()
+		p.ID = uint64(v)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if p.Creation, err = d.readLength(creation); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+func (d *Decoder) decodeRef(creation int) (Term, error) {
+	n, err := d.read16()
+	if err != nil {
+		return nil, err
+	}
+
+	var r Ref
+	if r.Node, err = d.decodeNode(); err != nil {
+		return nil, err
+	}
+	if r.Creation, err = d.readLength(creation); err != nil {
+		return nil, err
+	}
+	if err := d.checkLength(uint32(n), 4); err != nil {
+		return nil, err
+	}
+	r.ID = make([]uint32, n)
+	for i := range r.ID {
+		if r.ID[i], err = d.read32(); err != nil {
+			return nil, err
+		}
+	}
+	return r, nil
+}
+
+func (d *Decoder) decodeFun() (Term, error) {
+	start := d.offset
+	size, err := d.read32()
+	if err != nil {
+		return nil, err
+	}
+
+	var f Fun
+	if f.Arity, err = d.read8(); err != nil {
+		return nil, err
+	}
+	uniq, err := d.readBytes(uint32(len(f.Uniq)))
+	if err != nil {
+		return nil, err
+	}
+	copy(f.Uniq[:], uniq)
+	if f.Index, err = d.read32(); err != nil {
+		return nil, err
+	}
+	free, err := d.read32()
+	if err != nil {
+		return nil, err
+	}
+	if f.Module, err = d.decodeNode(); err != nil {
+		return nil, err
+	}
+
+	for _, field := range []*Term{&f.OldIndex, &f.OldUniq, &f.Pid} {
+		if *field, err = d.decodeTerm(); err != nil {
+			return nil, err
+		}
+	}
+	_, intIndex := unwrap(f.OldIndex).(Int)
+	_, intUniq := unwrap(f.OldUniq).(Int)
+	_, isPid := unwrap(f.Pid).(Pid)
+	if !intIndex || !intUniq || !isPid {
+		return nil, ErrInvalidFormat
+	}
+
+	if f.Free, err = d.decodeTerms(free); err != nil {
+		return nil, err
+	}
+	if d.offset-start != int(size) {
+		return nil, ErrInvalidFormat
+	}
+	return f, nil
+}
+
+func (d *Decoder) decodeExport() (Term, error) {
+	var x Export
+	var err error
+	if x.Module, err = d.decodeNode(); err != nil {
+		return nil, err
+	}
+	if x.Function, err = d.decodeNode(); err != nil {
+		return nil, err
+	}
+	arity, err := d.decodeTerm()
+	if err != nil {
+		return nil, err
+	}
+	v, ok := arity.(Int)
+	if !ok || v < 0 || v > math.MaxUint8 {
+		return nil, ErrInvalidFormat
+	}
+	x.Arity = uint8(v)
+	return x, nil
+}
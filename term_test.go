@@ -0,0 +1,157 @@
+package erlpack
+
+import (
+	"bytes"
+	"errors"
+	"math/big"
+	"reflect"
+	"strconv"
+	"testing"
+)
+
+// frame prefixes the version byte to the concatenated parts.
+func frame(parts ...[]byte) []byte {
+	return bytes.Join(append([][]byte{{FORMAT_VERSION}}, parts...), nil)
+}
+
+// atomExt writes a as ATOM_EXT, which term_to_binary no longer produces.
+func atomExt(a string) []byte {
+	return append([]byte{ATOM_EXT, 0, byte(len(a))}, a...)
+}
+
+// nonCanonicalFrames are frames Append would write differently if
+// ParseTerm did not record the tags they use.
+func nonCanonicalFrames() map[string][]byte {
+	return map[string][]byte{
+		"ATOM_EXT":              frame(atomExt("ok")),
+		"SMALL_ATOM_EXT":        frame([]byte{SMALL_ATOM_EXT, 2, 'o', 'k'}),
+		"short ATOM_UTF8_EXT":   frame([]byte{ATOM_UTF8_EXT, 0, 2, 'o', 'k'}),
+		"small INTEGER_EXT":     frame([]byte{INTEGER_EXT, 0, 0, 0, 5}),
+		"padded SMALL_BIG_EXT":  frame([]byte{SMALL_BIG_EXT, 3, 0, 5, 0, 0}),
+		"int32 SMALL_BIG_EXT":   frame([]byte{SMALL_BIG_EXT, 1, 1, 7}),
+		"short LARGE_BIG_EXT":   frame([]byte{LARGE_BIG_EXT, 0, 0, 0, 9, 0, 1, 2, 3, 4, 5, 6, 7, 8, 9}),
+		"padded big key":        frame([]byte{MAP_EXT, 0, 0, 0, 1, SMALL_BIG_EXT, 10, 1, 1, 2, 3, 4, 5, 6, 7, 8, 9, 0, NIL_EXT}),
+		"short LARGE_TUPLE_EXT": frame([]byte{LARGE_TUPLE_EXT, 0, 0, 0, 2, SMALL_INTEGER_EXT, 1}, atomExt("two")),
+		"empty LIST_EXT":        frame([]byte{LIST_EXT, 0, 0, 0, 0, NIL_EXT}),
+		"empty STRING_EXT":      frame([]byte{STRING_EXT, 0, 0}),
+		"empty LIST_EXT tail":   frame([]byte{LIST_EXT, 0, 0, 0, 1, SMALL_INTEGER_EXT, 1, LIST_EXT, 0, 0, 0, 0, NIL_EXT}),
+		"PID_EXT":               frame([]byte{PID_EXT}, atomExt("nonode@nohost"), []byte{0, 0, 0, 80, 0, 0, 0, 0, 1}),
+		"PORT_EXT":              frame([]byte{PORT_EXT, SMALL_ATOM_EXT, 1, 'n', 0, 0, 0, 5, 2}),
+		"short V4_PORT_EXT":     frame([]byte{V4_PORT_EXT, SMALL_ATOM_UTF8_EXT, 1, 'n', 0, 0, 0, 0, 0, 0, 0, 5, 0, 0, 0, 1}),
+		"NEW_REFERENCE_EXT":     frame([]byte{NEW_REFERENCE_EXT, 0, 2}, atomExt("n"), []byte{3, 0, 0, 0, 1, 0, 0, 0, 2}),
+		"legacy EXPORT_EXT":     frame([]byte{EXPORT_EXT}, atomExt("lists"), []byte{SMALL_ATOM_EXT, 3, 'm', 'a', 'p', SMALL_INTEGER_EXT, 2}),
+		"legacy NEW_FUN_EXT": frame(Fun{
+			Arity:    1,
+			Index:    3,
+			Module:   Encoded{Term: Atom("erl_eval"), Tag: ATOM_EXT},
+			OldIndex: Encoded{Term: Int(3), Tag: INTEGER_EXT},
+			OldUniq:  Int(12345678),
+			Pid:      Encoded{Term: Pid{Node: Encoded{Term: Atom("n"), Tag: SMALL_ATOM_EXT}, ID: 80}, Tag: PID_EXT},
+			Free:     []Term{Encoded{Term: List{}, Tag: LIST_EXT}},
+		}.Append(nil)),
+	}
+}
+
+func TestTermRoundTrip(t *testing.T) {
+	frames := nonCanonicalFrames()
+	for i, f := range testFrames() {
+		frames["test frame "+strconv.Itoa(i)] = f
+	}
+	huge := new(big.Int).Lsh(big.NewInt(1), 2100)
+	frames["canonical"] = NewEncoder().Pack(Tuple{
+		Pid{Node: Atom("nonode@nohost"), ID: 80, Creation: 1 << 20},
+		Port{Node: Atom("n"), ID: 5},
+		Port{Node: Atom("n"), ID: 1 << 40},
+		Ref{Node: Atom("n"), Creation: 3, ID: []uint32{1, 2, 3}},
+		Export{Module: Atom("lists"), Function: Atom("map"), Arity: 2},
+		BigInt{huge},
+		Int(-1 << 40),
+		List{Elems: []Term{Int(1)}, Tail: Atom("tail")},
+		Charlist(""),
+	})
+
+	for name, f := range frames {
+		term, err := ParseTerm(f)
+		if err != nil {
+			t.Errorf("%s: ParseTerm: %v", name, err)
+			continue
+		}
+		if out := term.Append([]byte{FORMAT_VERSION}); !bytes.Equal(out, f) {
+			t.Errorf("%s: Append = % x, want % x", name, out, f)
+		}
+		if out, err := Marshal(term); err != nil || !bytes.Equal(out, f) {
+			t.Errorf("%s: Marshal = % x, %v", name, out, err)
+		}
+	}
+}
+
+func TestTermEncoded(t *testing.T) {
+	frames := nonCanonicalFrames()
+	for name, want := range map[string]Term{
+		"ATOM_EXT":            Encoded{Term: Atom("ok"), Tag: ATOM_EXT},
+		"small INTEGER_EXT":   Encoded{Term: Int(5), Tag: INTEGER_EXT},
+		"int32 SMALL_BIG_EXT": Encoded{Term: Int(-7), Tag: SMALL_BIG_EXT, Digits: 1},
+		"empty LIST_EXT":      Encoded{Term: List{}, Tag: LIST_EXT},
+	} {
+		term, err := ParseTerm(frames[name])
+		if err != nil || !reflect.DeepEqual(term, want) {
+			t.Errorf("%s: ParseTerm = %#v, %v; want %#v", name, term, err, want)
+		}
+	}
+
+	// The same terms written the way Append chooses are not wrapped.
+	for _, want := range []Term{Atom("ok"), Int(5), Int(-7), List{}} {
+		term, err := ParseTerm(want.Append([]byte{FORMAT_VERSION}))
+		if err != nil || !reflect.DeepEqual(term, want) {
+			t.Errorf("ParseTerm(%#v) = %#v, %v", want, term, err)
+		}
+	}
+
+	for _, bad := range []Encoded{
+		{Term: Atom("ok"), Tag: INTEGER_EXT},
+		{Term: Int(5), Tag: SMALL_ATOM_EXT},
+		{Term: Tuple{}, Tag: SMALL_TUPLE_EXT},
+		{Term: List{Elems: []Term{Int(1)}}, Tag: LIST_EXT},
+		{Term: Pid{}, Tag: NEW_PORT_EXT},
+	} {
+		if _, err := Marshal(bad); !errors.Is(err, ErrInvalidEncoding) {
+			t.Errorf("Marshal(%#v) = %v, want ErrInvalidEncoding", bad, err)
+		}
+	}
+	for _, large := range []Encoded{
+		{Term: Int(1 << 40), Tag: INTEGER_EXT},
+		{Term: Atom(bytes.Repeat([]byte("x"), 256)), Tag: SMALL_ATOM_EXT},
+		{Term: Pid{Creation: 256}, Tag: PID_EXT},
+		{Term: Port{ID: 1 << 40}, Tag: NEW_PORT_EXT},
+	} {
+		if _, err := Marshal(large); !errors.Is(err, ErrTooLarge) {
+			t.Errorf("Marshal(%.40v) = %v, want ErrTooLarge", large, err)
+		}
+	}
+}
+
+func TestTermInexactRoundTrip(t *testing.T) {
+	compressed, text := compressedFrame(t)
+	term, err := ParseTerm(compressed)
+	if want := NewEncoder().Pack(Tuple{Atom("ok"), text}); err != nil || !bytes.Equal(term.Append([]byte{FORMAT_VERSION}), want) {
+		t.Errorf("ParseTerm(COMPRESSED) = %v, %v; want the uncompressed term", term, err)
+	}
+
+	signed := frame([]byte{SMALL_BIG_EXT, 5, 2, 1, 2, 3, 4, 5})
+	term, err = ParseTerm(signed)
+	want := frame([]byte{SMALL_BIG_EXT, 5, 1, 1, 2, 3, 4, 5})
+	if out := term.Append([]byte{FORMAT_VERSION}); err != nil || !bytes.Equal(out, want) {
+		t.Errorf("sign byte 2: Append = % x, %v; want % x", out, err, want)
+	}
+}
+
+func TestTupleMembers(t *testing.T) {
+	out, err := NewPrinter().AppendTerm(nil, Tuple{Atom("ok"), 1, "text", []any{2.5}, Int(3)})
+	if want := `{ok,1,<<"text">>,[2.5],3}`; err != nil || string(out) != want {
+		t.Errorf("AppendTerm = %s, %v; want %s", out, err, want)
+	}
+
+	if _, err := NewPrinter().AppendTerm(nil, Tuple{Atom("ok"), make(chan int)}); !errors.Is(err, ErrUnsupportedType) {
+		t.Errorf("AppendTerm with a chan member = %v, want ErrUnsupportedType", err)
+	}
+}
@@ -0,0 +1,385 @@
+package erlpack
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// traceBytes is how many bytes of a term the trace text shows in hex.
+const traceBytes = 8
+
+// TraceEntry describes one tag, or one group of fixed fields belonging to
+// the tag before it, found while tracing a frame. Offset counts from the
+// start of the frame like DecodeError.Offset, so in a COMPRESSED frame it
+// counts into the inflated term. Length is the declared length or
+// member count, or -1 for tags without one.
+type TraceEntry struct {
+	Offset int
+	Depth  int
+	Tag    byte
+	Field  string
+	Bytes  []byte
+	Length int64
+	Value  string
+	Err    error
+}
+
+func (e TraceEntry) Name() string {
+	switch {
+	case e.Field != "":
+		return e.Field
+	case tagNames[e.Tag] != "":
+		return tagNames[e.Tag]
+	case e.Err != nil && len(e.Bytes) == 0:
+		return "end of input"
+	}
+	return "tag " + strconv.Itoa(int(e.Tag))
+}
+
+// String formats the entry as a line of an annotated hexdump.
+func (e TraceEntry) String() string {
+	hex := fmt.Sprintf("% x", e.Bytes[:min(len(e.Bytes), traceBytes)])
+	if len(e.Bytes) > traceBytes {
+		hex += " .."
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "%08x  %-26s  %s%s", e.Offset, hex, strings.Repeat("  ", e.Depth), e.Name())
+	if e.Length >= 0 {
+		fmt.Fprintf(&b, " len=%d", e.Length)
+	}
+	if e.Value != "" {
+		b.WriteString(" " + e.Value)
+	}
+	if e.Err != nil {
+		b.WriteString("  <-- " + e.Err.Error())
+	}
+	return b.String()
+}
+
+type tracer struct {
+	d       *Decoder
+	entries []TraceEntry
+	err     error
+}
+
+// Trace walks data tag by tag and describes every term it passes. It keeps
+// going past errors that leave the following bytes readable, such as a
+// non-finite float, and otherwise stops at the failure point, which is the
+// entry whose Err is set. The first error found is also returned.
+func Trace(data []byte) ([]TraceEntry, error) {
+	t := &tracer{d: newScanDecoder()}
+
+	if len(data) == 0 || data[0] != FORMAT_VERSION {
+		e := TraceEntry{Tag: FORMAT_VERSION, Bytes: data[:min(len(data), 1)], Length: -1, Err: ErrInvalidFormat}
+		return []TraceEntry{e}, ErrInvalidFormat
+	}
+	t.entries = append(t.entries, TraceEntry{Tag: FORMAT_VERSION, Bytes: data[:1], Length: -1})
+	t.d.data = data[1:]
+
+	if err := t.term(0); err == nil && t.d.offset < len(t.d.data) {
+		t.entries = append(t.entries, TraceEntry{
+			Offset: t.d.offset + 1,
+			Field:  "trailing data",
+			Bytes:  t.d.data[t.d.offset:],
+			Length: int64(len(t.d.data) - t.d.offset),
+			Err:    ErrTrailingData,
+		})
+		t.record(ErrTrailingData)
+	}
+	return t.entries, t.err
+}
+
+// WriteTrace writes the trace of data to w as an annotated hexdump, one
+// entry per line indented by nesting depth.
+func WriteTrace(w io.Writer, data []byte) error {
+	entries, err := Trace(data)
+	for _, e := range entries {
+		if _, werr := fmt.Fprintln(w, e); werr != nil {
+			return werr
+		}
+	}
+	return err
+}
+
+func (t *tracer) record(err error) {
+	if t.err == nil {
+		t.err = err
+	}
+}
+
+func (t *tracer) add(start, depth int, tag byte, length int64, value string) *TraceEntry {
+	t.entries = append(t.entries, TraceEntry{
+		Offset: start + 1,
+		Depth:  depth,
+		Tag:    tag,
+		Bytes:  t.d.data[start:t.d.offset],
+		Length: length,
+		Value:  value,
+	})
+	return &t.entries[len(t.entries)-1]
+}
+
+// fail marks the failure point with the bytes left from start and stops
+// the walk.
+func (t *tracer) fail(start, depth int, tag byte, err error) error {
+	t.entries = append(t.entries, TraceEntry{
+		Offset: start + 1,
+		Depth:  depth,
+		Tag:    tag,
+		Bytes:  t.d.data[start:],
+		Length: -1,
+		Err:    err,
+	})
+	t.record(err)
+	return err
+}
+
+// field adds an entry for fixed fields read since start.
+func (t *tracer) field(start, depth int, name, value string) {
+	e := t.add(start, depth, 0, -1, value)
+	e.Field = name
+}
+
+func (t *tracer) term(depth int) error {
+	d := t.d
+	start := d.offset
+	tag, err := d.read8()
+	if err != nil {
+		return t.fail(start, depth, 0, err)
+	}
+	if d.MaxDepth > 0 && depth > d.MaxDepth {
+		return t.fail(start, depth, tag, ErrMaxDepth)
+	}
+
+	switch tag {
+	case SMALL_INTEGER_EXT:
+		v, err := d.read8()
+		if err != nil {
+			return t.fail(start, depth, tag, err)
+		}
+		t.add(start, depth, tag, -1, strconv.Itoa(int(v)))
+	case INTEGER_EXT:
+		v, err := d.read32()
+		if err != nil {
+			return t.fail(start, depth, tag, err)
+		}
+		t.add(start, depth, tag, -1, strconv.Itoa(int(int32(v))))
+	case NEW_FLOAT_EXT:
+		v, err := d.read64()
+		if err != nil {
+			return t.fail(start, depth, tag, err)
+		}
+		f := math.Float64frombits(v)
+		e := t.add(start, depth, tag, -1, strconv.FormatFloat(f, 'g', -1, 64))
+		if !isFinite(f) {
+			e.Err = ErrInvalidFloat
+			t.record(ErrInvalidFloat)
+		}
+	case ATOM_EXT, ATOM_UTF8_EXT, STRING_EXT:
+		return t.sized(start, depth, tag, 2)
+	case SMALL_ATOM_EXT, SMALL_ATOM_UTF8_EXT:
+		return t.sized(start, depth, tag, 1)
+	case BINARY_EXT:
+		return t.sized(start, depth, tag, 4)
+	case SMALL_BIG_EXT, LARGE_BIG_EXT:
+		width := 1
+		if tag == LARGE_BIG_EXT {
+			width = 4
+		}
+		neg, mag, err := d.decodeBigRaw(width)
+		if err != nil {
+			return t.fail(start, depth, tag, err)
+		}
+		t.add(start, depth, tag, int64(len(mag)), newBigInt(neg, mag).String())
+	case NIL_EXT:
+		t.add(start, depth, tag, -1, "")
+	case SMALL_TUPLE_EXT:
+		return t.members(start, depth, tag, 1, 1)
+	case LARGE_TUPLE_EXT:
+		return t.members(start, depth, tag, 4, 1)
+	case MAP_EXT:
+		return t.members(start, depth, tag, 4, 2)
+	case LIST_EXT:
+		if err := t.members(start, depth, tag, 4, 1); err != nil {
+			return err
+		}
+		return t.term(depth + 1)
+	case NEW_PID_EXT, PID_EXT:
+		return t.node(start, depth, tag, "id serial creation", 4, 4, creationWidth(tag))
+	case NEW_PORT_EXT, PORT_EXT:
+		return t.node(start, depth, tag, "id creation", 4, creationWidth(tag))
+	case V4_PORT_EXT:
+		return t.node(start, depth, tag, "id creation", 8, 4)
+	case NEWER_REFERENCE_EXT, NEW_REFERENCE_EXT:
+		return t.ref(start, depth, tag)
+	case NEW_FUN_EXT:
+		return t.fun(start, depth, tag)
+	case EXPORT_EXT:
+		t.add(start, depth, tag, -1, "")
+		for range 3 {
+			if err := t.term(depth + 1); err != nil {
+				return err
+			}
+		}
+	case COMPRESSED:
+		if depth > 0 {
+			return t.fail(start, depth, tag, ErrNestedCompressed)
+		}
+		var size int64 = -1
+		if len(d.data)-d.offset >= 4 {
+			size = int64(binary.BigEndian.Uint32(d.data[d.offset:]))
+		}
+		out, end, err := d.inflate()
+		if err != nil {
+			return t.fail(start, depth, tag, err)
+		}
+		d.offset = end
+		t.add(start, depth, tag, size, fmt.Sprintf("deflated=%d", end-start-5))
+
+		data := d.data
+		d.data, d.offset = out, 0
+		err = t.term(depth + 1)
+		if err == nil && d.offset < len(d.data) {
+			err = t.fail(d.offset, depth+1, 0, ErrInflateSize)
+		}
+		d.data, d.offset = data, end
+		return err
+	default:
+		return t.fail(start, depth, tag, ErrUnsupportedTag)
+	}
+
+	return nil
+}
+
+func creationWidth(tag byte) int {
+	if tag == PID_EXT || tag == PORT_EXT || tag == NEW_REFERENCE_EXT {
+		return 1
+	}
+	return 4
+}
+
+func (t *tracer) sized(start, depth int, tag byte, width int) error {
+	n, err := t.d.readLength(width)
+	if err != nil {
+		return t.fail(start, depth, tag, err)
+	}
+	b, err := t.d.readBinary(n)
+	if err != nil {
+		err = t.fail(start, depth, tag, err)
+		t.entries[len(t.entries)-1].Length = int64(n)
+		return err
+	}
+
+	value := b
+	if len(value) > 64 {
+		value = value[:64]
+	}
+	text := strconv.Quote(string(value))
+	if len(value) < len(b) {
+		text += ".."
+	}
+	t.add(start, depth, tag, int64(n), text)
+	return nil
+}
+
+// members traces a container header and its n members, each a single term
+// or, for maps, a key and value pair.
+func (t *tracer) members(start, depth int, tag byte, width, per int) error {
+	n, err := t.d.readLength(width)
+	if err != nil {
+		return t.fail(start, depth, tag, err)
+	}
+	t.add(start, depth, tag, int64(n), "")
+
+	for range n {
+		for range per {
+			if err := t.term(depth + 1); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// node traces a pid or port: the node atom followed by fixed-width
+// integer fields.
+func (t *tracer) node(start, depth int, tag byte, names string, widths ...int) error {
+	t.add(start, depth, tag, -1, "")
+	if err := t.term(depth + 1); err != nil {
+		return err
+	}
+	return t.fields(depth+1, names, widths...)
+}
+
+func (t *tracer) fields(depth int, names string, widths ...int) error {
+	d := t.d
+	start := d.offset
+	values := make([]string, len(widths))
+	for i, w := range widths {
+		var v uint64
+		var err error
+		if w == 8 {
+			v, err = d.read64()
+		} else {
+			var n uint32
+			n, err = d.readLength(w)
+			v = uint64(n)
+		}
+		if err != nil {
+			e := t.fail(start, depth, 0, err)
+			t.entries[len(t.entries)-1].Field = names
+			return e
+		}
+		values[i] = strconv.FormatUint(v, 10)
+	}
+	t.field(start, depth, names, strings.Join(values, " "))
+	return nil
+}
+
+func (t *tracer) ref(start, depth int, tag byte) error {
+	d := t.d
+	n, err := d.read16()
+	if err != nil {
+		return t.fail(start, depth, tag, err)
+	}
+	t.add(start, depth, tag, int64(n), "")
+	if err := t.term(depth + 1); err != nil {
+		return err
+	}
+
+	widths := []int{creationWidth(tag)}
+	names := "creation"
+	for range n {
+		widths = append(widths, 4)
+	}
+	if n > 0 {
+		names += " id"
+	}
+	return t.fields(depth+1, names, widths...)
+}
+
+func (t *tracer) fun(start, depth int, tag byte) error {
+	d := t.d
+	if err := d.checkLength(29, 1); err != nil {
+		return t.fail(start, depth, tag, err)
+	}
+	size, _ := d.read32()
+	arity, _ := d.read8()
+	d.offset += 16
+	index, _ := d.read32()
+	free, _ := d.read32()
+	t.add(start, depth, tag, int64(size), fmt.Sprintf("arity=%d index=%d free=%d", arity, index, free))
+
+	// Module, old index, old uniq and pid come before the free variables.
+	for range uint64(free) + 4 {
+		if err := t.term(depth + 1); err != nil {
+			return err
+		}
+	}
+	return nil
+}
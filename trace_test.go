@@ -0,0 +1,66 @@
+package erlpack
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+func TestWriteTraceGolden(t *testing.T) {
+	valid, err := PackLiteral(`{ok, [1, 2.5], <<"text">>, #{a => 300}}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tt := range []struct {
+		name  string
+		frame []byte
+		want  string
+		err   error
+	}{
+		{
+			name:  "valid",
+			frame: valid,
+			want: "" +
+				"00000000  83                          FORMAT_VERSION\n" +
+				"00000001  68 04                       SMALL_TUPLE_EXT len=4\n" +
+				"00000003  77 02 6f 6b                   SMALL_ATOM_UTF8_EXT len=2 \"ok\"\n" +
+				"00000007  6c 00 00 00 02                LIST_EXT len=2\n" +
+				"0000000c  61 01                           SMALL_INTEGER_EXT 1\n" +
+				"0000000e  46 40 04 00 00 00 00 00 ..      NEW_FLOAT_EXT 2.5\n" +
+				"00000017  6a                              NIL_EXT\n" +
+				"00000018  6d 00 00 00 04 74 65 78 ..    BINARY_EXT len=4 \"text\"\n" +
+				"00000021  74 00 00 00 01                MAP_EXT len=1\n" +
+				"00000026  77 01 61                        SMALL_ATOM_UTF8_EXT len=1 \"a\"\n" +
+				"00000029  62 00 00 01 2c                  INTEGER_EXT 300\n",
+		},
+		{
+			name:  "truncated",
+			frame: []byte{FORMAT_VERSION, SMALL_TUPLE_EXT, 2, SMALL_INTEGER_EXT, 1, NEW_FLOAT_EXT, 0x3f, 0xf8, 0},
+			want: "" +
+				"00000000  83                          FORMAT_VERSION\n" +
+				"00000001  68 02                       SMALL_TUPLE_EXT len=2\n" +
+				"00000003  61 01                         SMALL_INTEGER_EXT 1\n" +
+				"00000005  46 3f f8 00                   NEW_FLOAT_EXT  <-- read64 out of bounds\n",
+			err: ErrRead64OutOfBound,
+		},
+		{
+			name:  "trailing data",
+			frame: []byte{FORMAT_VERSION, SMALL_ATOM_UTF8_EXT, 2, 'o', 'k', 1, 2, 3},
+			want: "" +
+				"00000000  83                          FORMAT_VERSION\n" +
+				"00000001  77 02 6f 6b                 SMALL_ATOM_UTF8_EXT len=2 \"ok\"\n" +
+				"00000005  01 02 03                    trailing data len=3  <-- trailing data after term\n",
+			err: ErrTrailingData,
+		},
+	} {
+		var out bytes.Buffer
+		err := WriteTrace(&out, tt.frame)
+		if !errors.Is(err, tt.err) {
+			t.Errorf("%s: WriteTrace = %v, want %v", tt.name, err, tt.err)
+		}
+		if out.String() != tt.want {
+			t.Errorf("%s: WriteTrace wrote\n%s\nwant\n%s", tt.name, out.String(), tt.want)
+		}
+	}
+}
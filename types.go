@@ -0,0 +1,102 @@
+package erlpack
+
+import "math/big"
+
+// Term is a node of the ETF syntax tree built by ParseTerm. Append encodes
+// the term the way term_to_binary does, except for terms ParseTerm wrapped
+// in Encoded, so parsing and appending a frame reproduces it byte for byte.
+type Term interface {
+	Append(dst []byte) []byte
+
+	appendTerm(e *Encoder, dst []byte) ([]byte, error)
+}
+
+// Atom is encoded as an Erlang atom rather than a binary.
+type Atom string
+
+// Tuple is encoded as an Erlang tuple rather than a list.
+type Tuple []any
+
+type Binary []byte
+
+// Charlist is a list of small integers, which ETF stores as STRING_EXT.
+type Charlist []byte
+
+type Int int64
+
+// BigInt holds integers that do not fit in an Int.
+type BigInt struct {
+	*big.Int
+}
+
+type Float float64
+
+// List is a list with an optional improper tail. A nil Tail is the empty
+// list that terminates proper lists.
+type List struct {
+	Elems []Term
+	Tail  Term
+}
+
+// Map keeps its pairs in encoding order, since ETF map keys can be any
+// term.
+type Map []Pair
+
+type Pair struct {
+	Key   Term
+	Value Term
+}
+
+// Encoded is a term ParseTerm read under a tag other than the one Append
+// would choose, such as ATOM_EXT, an INTEGER_EXT small enough for
+// SMALL_INTEGER_EXT, or PID_EXT. Appending it writes Term under Tag again.
+// Digits is the magnitude length of a bignum with leading zero bytes, and
+// is zero otherwise. The sign of a bignum zero is not kept.
+type Encoded struct {
+	Term   Term
+	Tag    byte
+	Digits int
+}
+
+// Pid, Port and Ref hold their Node as an Atom, or an Encoded atom. A nil
+// Node is encoded as the empty atom.
+type Pid struct {
+	Node     Term
+	ID       uint32
+	Serial   uint32
+	Creation uint32
+}
+
+type Port struct {
+	Node     Term
+	ID       uint64
+	Creation uint32
+}
+
+type Ref struct {
+	Node     Term
+	Creation uint32
+	ID       []uint32
+}
+
+// Fun is a local fun together with its captured free variables. Module is
+// an atom, OldIndex and OldUniq are integers and Pid is a Pid, each
+// possibly Encoded.
+type Fun struct {
+	Arity    uint8
+	Uniq     [16]byte
+	Index    uint32
+	Module   Term
+	OldIndex Term
+	OldUniq  Term
+	Pid      Term
+	Free     []Term
+}
+
+// Export is an external fun written as fun Module:Function/Arity, where
+// Module and Function are atoms, possibly Encoded.
+type Export struct {
+	Module   Term
+	Function Term
+	Arity    uint8
+}
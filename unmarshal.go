@@ -0,0 +1,371 @@
+package erlpack
+
+import (
+	"encoding"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+var (
+	ErrInvalidUnmarshal = errors.New("unmarshal target must be a non-nil pointer")
+	ErrUnmarshalType    = errors.New("cannot unmarshal")
+
+	fieldCache sync.Map
+
+	jsonUnmarshalerType = reflect.TypeFor[json.Unmarshaler]()
+	textUnmarshalerType = reflect.TypeFor[encoding.TextUnmarshaler]()
+)
+
+type decodeField struct {
+	name   string
+	index  []int
+	quoted bool
+}
+
+// Unmarshal decodes data into the value v points to. Structs are read
+// with the layout Struct.FillMap writes: json tag names, "-", embedded and
+// flatten structs, and ",string" fields holding their value as text. Map
+// keys match field names exactly, or else case-insensitively.
+func Unmarshal(data []byte, v any) error {
+	return newScanDecoder().Unmarshal(data, v)
+}
+
+func (d *Decoder) Unmarshal(data []byte, v any) error {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Pointer || rv.IsNil() {
+		return ErrInvalidUnmarshal
+	}
+
+	val, err := d.UnpackValue(data)
+	if err != nil {
+		return err
+	}
+
+	return assignValue(rv.Elem(), val)
+}
+
+func typeError(src any, dst reflect.Value) error {
+	return fmt.Errorf("%w %T into %s", ErrUnmarshalType, src, dst.Type())
+}
+
+func fieldError(err error, name string) error {
+	return fmt.Errorf("%w (field %s)", err, name)
+}
+
+func assignValue(dst reflect.Value, src any) error {
+	if src == nil {
+		switch dst.Kind() {
+		case reflect.Pointer, reflect.Map, reflect.Slice, reflect.Interface:
+			dst.SetZero()
+		}
+		return nil
+	}
+
+	if dst.Kind() != reflect.Pointer && dst.CanAddr() {
+		pt := dst.Addr().Type()
+		if pt.Implements(jsonUnmarshalerType) {
+			b, err := json.Marshal(src)
+			if err != nil {
+				return err
+			}
+			return dst.Addr().Interface().(json.Unmarshaler).UnmarshalJSON(b)
+		}
+		if s, ok := src.(string); ok && pt.Implements(textUnmarshalerType) {
+			return dst.Addr().Interface().(encoding.TextUnmarshaler).UnmarshalText([]byte(s))
+		}
+	}
+
+	switch dst.Kind() {
+	case reflect.Pointer:
+		if dst.IsNil() {
+			dst.Set(reflect.New(dst.Type().Elem()))
+		}
+		return assignValue(dst.Elem(), src)
+	case reflect.Interface:
+		if dst.NumMethod() != 0 {
+			return typeError(src, dst)
+		}
+		dst.Set(reflect.ValueOf(src))
+	case reflect.Bool:
+		v, ok := src.(bool)
+		if !ok {
+			return typeError(src, dst)
+		}
+		dst.SetBool(v)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		v, ok := src.(int64)
+		if !ok || dst.OverflowInt(v) {
+			return typeError(src, dst)
+		}
+		dst.SetInt(v)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		var v uint64
+		switch n := src.(type) {
+		case int64:
+			if n < 0 {
+				return typeError(src, dst)
+			}
+			v = uint64(n)
+		case *big.Int:
+			if !n.IsUint64() {
+				return typeError(src, dst)
+			}
+			v = n.Uint64()
+		default:
+			return typeError(src, dst)
+		}
+		if dst.OverflowUint(v) {
+			return typeError(src, dst)
+		}
+		dst.SetUint(v)
+	case reflect.Float32, reflect.Float64:
+		switch v := src.(type) {
+		case float64:
+			dst.SetFloat(v)
+		case int64:
+			dst.SetFloat(float64(v))
+		case *big.Int:
+			f, _ := new(big.Float).SetInt(v).Float64()
+			dst.SetFloat(f)
+		default:
+			return typeError(src, dst)
+		}
+	case reflect.String:
+		switch v := src.(type) {
+		case string:
+			dst.SetString(v)
+		case Atom:
+			dst.SetString(string(v))
+		default:
+			return typeError(src, dst)
+		}
+	case reflect.Slice:
+		if s, ok := src.(string); ok && dst.Type().Elem().Kind() == reflect.Uint8 {
+			dst.SetBytes([]byte(s))
+			return nil
+		}
+		v, ok := listValue(src)
+		if !ok {
+			return typeError(src, dst)
+		}
+		out := reflect.MakeSlice(dst.Type(), len(v), len(v))
+		for i := range v {
+			if err := assignValue(out.Index(i), v[i]); err != nil {
+				return fieldError(err, strconv.Itoa(i))
+			}
+		}
+		dst.Set(out)
+	case reflect.Array:
+		v, ok := listValue(src)
+		if !ok {
+			return typeError(src, dst)
+		}
+		for i := range dst.Len() {
+			if i >= len(v) {
+				dst.Index(i).SetZero()
+				continue
+			}
+			if err := assignValue(dst.Index(i), v[i]); err != nil {
+				return fieldError(err, strconv.Itoa(i))
+			}
+		}
+	case reflect.Map:
+		v, ok := src.(map[string]any)
+		if !ok {
+			return typeError(src, dst)
+		}
+		return assignMap(dst, v)
+	case reflect.Struct:
+		v, ok := src.(map[string]any)
+		if !ok {
+			return typeError(src, dst)
+		}
+		return assignStruct(dst, v)
+	default:
+		return typeError(src, dst)
+	}
+
+	return nil
+}
+
+func listValue(src any) ([]any, bool) {
+	switch v := src.(type) {
+	case []any:
+		return v, true
+	case Tuple:
+		return v, true
+	}
+	return nil, false
+}
+
+func assignMap(dst reflect.Value, src map[string]any) error {
+	t := dst.Type()
+	if dst.IsNil() {
+		dst.Set(reflect.MakeMapWithSize(t, len(src)))
+	}
+
+	for k, v := range src {
+		key := reflect.New(t.Key()).Elem()
+		switch {
+		case reflect.PointerTo(t.Key()).Implements(textUnmarshalerType):
+			if err := key.Addr().Interface().(encoding.TextUnmarshaler).UnmarshalText([]byte(k)); err != nil {
+				return err
+			}
+		case key.Kind() == reflect.String:
+			key.SetString(k)
+		default:
+			if err := assignQuoted(key, k); err != nil {
+				return err
+			}
+		}
+
+		elem := reflect.New(t.Elem()).Elem()
+		if err := assignValue(elem, v); err != nil {
+			return fieldError(err, k)
+		}
+		dst.SetMapIndex(key, elem)
+	}
+
+	return nil
+}
+
+func assignStruct(dst reflect.Value, src map[string]any) error {
+	fields := cachedFields(dst.Type())
+
+	for k, v := range src {
+		f := lookupField(fields, k)
+		if f == nil {
+			continue
+		}
+		// A key matching the field name exactly takes precedence.
+		if _, exact := src[f.name]; exact && k != f.name {
+			continue
+		}
+
+		fv := dst.FieldByIndex(f.index)
+		var err error
+		if s, ok := v.(string); ok && f.quoted {
+			err = assignQuoted(fv, s)
+		} else {
+			err = assignValue(fv, v)
+		}
+		if err != nil {
+			return fieldError(err, k)
+		}
+	}
+
+	return nil
+}
+
+func assignQuoted(dst reflect.Value, s string) error {
+	if dst.Kind() == reflect.Pointer {
+		if dst.IsNil() {
+			dst.Set(reflect.New(dst.Type().Elem()))
+		}
+		dst = dst.Elem()
+	}
+
+	if dst.CanAddr() && dst.Addr().Type().Implements(textUnmarshalerType) {
+		return dst.Addr().Interface().(encoding.TextUnmarshaler).UnmarshalText([]byte(s))
+	}
+
+	switch dst.Kind() {
+	case reflect.String:
+		dst.SetString(s)
+	case reflect.Bool:
+		v, err := strconv.ParseBool(s)
+		if err != nil {
+			return err
+		}
+		dst.SetBool(v)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		v, err := strconv.ParseInt(s, 10, dst.Type().Bits())
+		if err != nil {
+			return err
+		}
+		dst.SetInt(v)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		v, err := strconv.ParseUint(s, 10, dst.Type().Bits())
+		if err != nil {
+			return err
+		}
+		dst.SetUint(v)
+	case reflect.Float32, reflect.Float64:
+		v, err := strconv.ParseFloat(s, dst.Type().Bits())
+		if err != nil {
+			return err
+		}
+		dst.SetFloat(v)
+	default:
+		return typeError(s, dst)
+	}
+
+	return nil
+}
+
+func lookupField(fields []decodeField, name string) *decodeField {
+	for i := range fields {
+		if fields[i].name == name {
+			return &fields[i]
+		}
+	}
+	for i := range fields {
+		if strings.EqualFold(fields[i].name, name) {
+			return &fields[i]
+		}
+	}
+	return nil
+}
+
+func cachedFields(t reflect.Type) []decodeField {
+	if f, ok := fieldCache.Load(t); ok {
+		return f.([]decodeField)
+	}
+	f, _ := fieldCache.LoadOrStore(t, collectDecodeFields(t, nil))
+	return f.([]decodeField)
+}
+
+// collectDecodeFields mirrors the layout Struct.FillMap produces so that
+// encoding a struct and decoding it back are symmetric.
+func collectDecodeFields(t reflect.Type, index []int) []decodeField {
+	var fields []decodeField
+
+	for i := range t.NumField() {
+		field := t.Field(i)
+		if field.PkgPath != "" {
+			continue
+		}
+
+		tag := field.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+
+		idx := append(index[:len(index):len(index)], i)
+		tagName, tagOpts := parseTag(tag)
+
+		if field.Type.Kind() == reflect.Struct && (field.Anonymous && len(tagOpts) == 0 || tagOpts.Has("flatten")) {
+			fields = append(fields, collectDecodeFields(field.Type, idx)...)
+			continue
+		}
+
+		name := field.Name
+		if tagName != "" {
+			name = tagName
+		}
+
+		fields = append(fields, decodeField{
+			name:   name,
+			index:  idx,
+			quoted: tagOpts.Has("string"),
+		})
+	}
+
+	return fields
+}
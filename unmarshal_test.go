@@ -0,0 +1,199 @@
+package erlpack
+
+import (
+	"errors"
+	"math/big"
+	"reflect"
+	"testing"
+)
+
+type quotedFields struct {
+	Quiet int      `json:",string"`
+	Ratio float64  `json:"ratio,string"`
+	On    bool     `json:"on,string"`
+	Name  string   `json:"name,string"`
+	Ptr   *uint16  `json:"ptr,string"`
+	List  []string `json:"list,string"`
+}
+
+type omitted struct {
+	Kept    int    `json:"kept"`
+	Skipped string `json:"-"`
+	Empty   string `json:"empty,omitempty"`
+	Zero    *int   `json:"zero,omitempty"`
+	Renamed bool   `json:"other_name"`
+}
+
+type inner struct {
+	A int    `json:"a"`
+	B string `json:"b"`
+}
+
+type Base struct {
+	ID   string `json:"id"`
+	Kind int    `json:"kind"`
+}
+
+type nesting struct {
+	Base
+	Flat  inner `json:",flatten"`
+	Child inner `json:"child"`
+}
+
+type flattenedOnly struct {
+	Flat inner `json:"flat,flatten"`
+	C    int   `json:"c"`
+}
+
+type erlangFields struct {
+	Reply Tuple    `json:"reply"`
+	Kind  Atom     `json:"kind"`
+	Big   *big.Int `json:"big"`
+	Small *big.Int `json:"small"`
+	Pair  [2]int   `json:"pair"`
+}
+
+func TestUnmarshalRoundTrip(t *testing.T) {
+	port := uint16(8080)
+	huge, _ := new(big.Int).SetString("123456789012345678901234567890", 10)
+
+	for _, tt := range []struct {
+		name string
+		in   any
+		want map[string]any // the map Marshal writes, nil to skip the check
+	}{
+		{
+			name: "string",
+			in:   quotedFields{Quiet: 3, Ratio: 1.5, On: true, Name: "n", Ptr: &port, List: []string{"x"}},
+			want: map[string]any{"Quiet": "3", "ratio": "1.5", "on": "true", "name": "n", "ptr": "8080", "list": []any{"x"}},
+		},
+		{
+			name: "string nil pointer",
+			in:   quotedFields{},
+			want: map[string]any{"Quiet": "0", "ratio": "0", "on": "false", "name": "", "ptr": nil, "list": nil},
+		},
+		{
+			name: "names and omitempty",
+			in:   omitted{Kept: 1, Skipped: "gone", Renamed: true},
+			want: map[string]any{"kept": int64(1), "other_name": true},
+		},
+		{
+			name: "omitempty set",
+			in:   omitted{Empty: "e", Zero: new(int)},
+			want: map[string]any{"kept": int64(0), "empty": "e", "zero": int64(0), "other_name": false},
+		},
+		{
+			name: "flatten",
+			in:   flattenedOnly{Flat: inner{A: 1, B: "b"}, C: 2},
+			want: map[string]any{"a": int64(1), "b": "b", "c": int64(2)},
+		},
+		{
+			name: "embedded",
+			in:   nesting{Base: Base{ID: "x", Kind: 1}, Flat: inner{A: 3}, Child: inner{A: 2, B: "y"}},
+			want: map[string]any{
+				"id": "x", "kind": int64(1), "a": int64(3), "b": "",
+				"child": map[string]any{"a": int64(2), "b": "y"},
+			},
+		},
+		{
+			name: "erlang types",
+			in: erlangFields{
+				Reply: Tuple{Atom("reply"), int64(1)},
+				Kind:  "ok",
+				Big:   huge,
+				Small: big.NewInt(-5),
+				Pair:  [2]int{3, 4},
+			},
+		},
+	} {
+		frame, err := Marshal(tt.in)
+		if err != nil {
+			t.Errorf("%s: Marshal: %v", tt.name, err)
+			continue
+		}
+
+		if tt.want != nil {
+			var m map[string]any
+			if err := Unmarshal(frame, &m); err != nil || !reflect.DeepEqual(m, tt.want) {
+				t.Errorf("%s: Marshal wrote %#v, %v; want %#v", tt.name, m, err, tt.want)
+			}
+		}
+
+		out := reflect.New(reflect.TypeOf(tt.in))
+		if err := Unmarshal(frame, out.Interface()); err != nil {
+			t.Errorf("%s: Unmarshal: %v", tt.name, err)
+			continue
+		}
+		want := reflect.ValueOf(tt.in)
+		if v, ok := tt.in.(omitted); ok {
+			v.Skipped = ""
+			want = reflect.ValueOf(v)
+		}
+		if got := out.Elem().Interface(); !reflect.DeepEqual(got, want.Interface()) {
+			t.Errorf("%s: round trip = %+v, want %+v", tt.name, got, want.Interface())
+		}
+	}
+}
+
+func TestUnmarshalFieldNames(t *testing.T) {
+	frame, err := Marshal(map[string]any{"KEPT": 7, "Other_Name": true, "Skipped": "x", "unknown": 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var o omitted
+	if err := Unmarshal(frame, &o); err != nil || o != (omitted{Kept: 7, Renamed: true}) {
+		t.Errorf("Unmarshal = %+v, %v", o, err)
+	}
+
+	// An exact match wins over a case-insensitive one.
+	frame, err = Marshal(map[string]any{"a": 1, "A": 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for range 10 {
+		var in inner
+		if err := Unmarshal(frame, &in); err != nil || in.A != 1 {
+			t.Fatalf("Unmarshal = %+v, %v; want A from the exact key", in, err)
+		}
+	}
+}
+
+func TestUnmarshalErrors(t *testing.T) {
+	type small struct {
+		N    int8    `json:"n"`
+		U    uint    `json:"u"`
+		List []uint8 `json:"list"`
+		Q    int8    `json:"q,string"`
+	}
+
+	for _, tt := range []struct {
+		in   map[string]any
+		want string
+	}{
+		{map[string]any{"n": 300}, "cannot unmarshal int64 into int8 (field n)"},
+		{map[string]any{"u": -1}, "cannot unmarshal int64 into uint (field u)"},
+		{map[string]any{"u": new(big.Int).Lsh(big.NewInt(1), 64)}, "cannot unmarshal *big.Int into uint (field u)"},
+		{map[string]any{"list": []any{1, 256}}, "cannot unmarshal int64 into uint8 (field 1) (field list)"},
+		{map[string]any{"n": "1"}, "cannot unmarshal string into int8 (field n)"},
+		{map[string]any{"q": "200"}, `strconv.ParseInt: parsing "200": value out of range (field q)`},
+	} {
+		frame, err := Marshal(tt.in)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var s small
+		err = Unmarshal(frame, &s)
+		if err == nil || err.Error() != tt.want {
+			t.Errorf("Unmarshal(%v) = %v, want %q", tt.in, err, tt.want)
+		}
+		if tt.in["q"] == nil && !errors.Is(err, ErrUnmarshalType) {
+			t.Errorf("Unmarshal(%v) = %v, want ErrUnmarshalType", tt.in, err)
+		}
+	}
+
+	var s struct{}
+	if err := Unmarshal([]byte{FORMAT_VERSION, NIL_EXT}, s); !errors.Is(err, ErrInvalidUnmarshal) {
+		t.Errorf("Unmarshal into a non-pointer = %v", err)
+	}
+}
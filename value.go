@@ -0,0 +1,204 @@
+package erlpack
+
+import (
+	"math"
+	"strconv"
+)
+
+func atomValue(b []byte) any {
+	switch string(b) {
+	case "nil", "null":
+		return nil
+	case "true":
+		return true
+	case "false":
+		return false
+	}
+	return Atom(b)
+}
+
+func (d *Decoder) decodeValueArray(n uint32) ([]any, error) {
+	if err := d.enter(n, 1); err != nil {
+		return nil, err
+	}
+	out := make([]any, 0, n)
+	for i := range n {
+		v, err := d.decodeValue()
+		if err != nil {
+			return nil, decodePath(err, strconv.FormatUint(uint64(i), 10))
+		}
+		out = append(out, v)
+	}
+	d.leave()
+	return out, nil
+}
+
+func (d *Decoder) decodeValueList() ([]any, error) {
+	l, err := d.readLength(4)
+	if err != nil {
+		return nil, err
+	}
+	out, err := d.decodeValueArray(l)
+	if err != nil {
+		return nil, err
+	}
+	tail, err := d.read8()
+	if err != nil || tail != NIL_EXT {
+		return nil, d.fail(ErrListTailMissing, tail, "list tail")
+	}
+	return out, nil
+}
+
+func (d *Decoder) decodeValueTuple(width int) (any, error) {
+	n, err := d.readLength(width)
+	if err != nil {
+		return nil, err
+	}
+	out, err := d.decodeValueArray(n)
+	if err != nil {
+		return nil, err
+	}
+	return Tuple(out), nil
+}
+
+func (d *Decoder) decodeValueMap() (map[string]any, error) {
+	l, err := d.readLength(4)
+	if err != nil {
+		return nil, err
+	}
+	if err := d.enter(l, 2); err != nil {
+		return nil, err
+	}
+	out := make(map[string]any, l)
+	for range l {
+		keyOff := d.offset
+		key, err := d.decodeKey()
+		if err != nil {
+			return nil, d.failKey(err, keyOff)
+		}
+		k := string(key)
+		v, err := d.decodeValue()
+		if err != nil {
+			return nil, decodePath(err, k)
+		}
+		out[k] = v
+	}
+	d.leave()
+	return out, nil
+}
+
+// decodeValueBig returns an int64 when the bignum fits and a *big.Int
+// otherwise.
+func (d *Decoder) decodeValueBig(width int) (any, error) {
+	neg, mag, err := d.decodeBigRaw(width)
+	if err != nil {
+		return nil, err
+	}
+
+	if value, ok := bigUint64(mag); ok {
+		if !neg && value <= math.MaxInt64 {
+			return int64(value), nil
+		}
+		if neg && value <= 1<<63 {
+			return int64(-value), nil
+		}
+	}
+
+	return newBigInt(neg, mag), nil
+}
+
+func (d *Decoder) decodeValue() (any, error) {
+	start := d.offset
+	tag, err := d.read8()
+	if err != nil {
+		return nil, d.fail(err, 0, "tag")
+	}
+
+	v, err := d.decodeValueTag(tag)
+	if err == ErrUnsupportedTag {
+		d.offset = start
+		return nil, d.fail(err, tag, "term")
+	}
+	if err != nil {
+		return nil, d.fail(err, tag, tagNames[tag])
+	}
+	return v, nil
+}
+
+func (d *Decoder) decodeValueTag(tag byte) (any, error) {
+	switch tag {
+	case SMALL_INTEGER_EXT:
+		v, err := d.read8()
+		if err != nil {
+			return nil, err
+		}
+		return int64(v), nil
+	case INTEGER_EXT:
+		v, err := d.read32()
+		if err != nil {
+			return nil, err
+		}
+		return int64(int32(v)), nil
+	case NEW_FLOAT_EXT:
+		f, err := d.readFloat()
+		if err != nil {
+			return nil, err
+		}
+		return f, nil
+	case ATOM_EXT, ATOM_UTF8_EXT:
+		b, err := d.readSized(2)
+		if err != nil {
+			return nil, err
+		}
+		return atomValue(b), nil
+	case SMALL_ATOM_EXT, SMALL_ATOM_UTF8_EXT:
+		b, err := d.readSized(1)
+		if err != nil {
+			return nil, err
+		}
+		return atomValue(b), nil
+	case STRING_EXT:
+		b, err := d.readSized(2)
+		if err != nil {
+			return nil, err
+		}
+		return string(b), nil
+	case BINARY_EXT:
+		b, err := d.readSized(4)
+		if err != nil {
+			return nil, err
+		}
+		return string(b), nil
+	case LIST_EXT:
+		return d.decodeValueList()
+	case SMALL_TUPLE_EXT:
+		return d.decodeValueTuple(1)
+	case LARGE_TUPLE_EXT:
+		return d.decodeValueTuple(4)
+	case MAP_EXT:
+		return d.decodeValueMap()
+	case NIL_EXT:
+		return []any{}, nil
+	case SMALL_BIG_EXT:
+		return d.decodeValueBig(1)
+	case LARGE_BIG_EXT:
+		return d.decodeValueBig(4)
+	case COMPRESSED:
+		return nil, ErrNestedCompressed
+	default:
+		return nil, ErrUnsupportedTag
+	}
+}
+
+func (d *Decoder) UnpackValue(data []byte) (any, error) {
+	if err := d.begin(data); err != nil {
+		return nil, err
+	}
+
+	var v any
+	err := d.decodeFrame(func() (err error) {
+		v, err = d.decodeValue()
+		return err
+	})
+	return v, err
+}
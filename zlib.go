@@ -0,0 +1,122 @@
+package erlpack
+
+import (
+	"bytes"
+	"compress/flate"
+	"errors"
+	"io"
+	"slices"
+)
+
+const zlibWindow = 32 * 1024
+
+var (
+	zlibSuffix = []byte{0x00, 0x00, 0xff, 0xff}
+
+	ErrZlibHeader = errors.New("invalid zlib header")
+)
+
+// ZlibStream inflates Discord's zlib-stream gateway transport. Every
+// message is flushed with a zlib sync flush, so frames are buffered until
+// the 00 00 ff ff suffix arrives and each message is then inflated against
+// the history window of all previous messages.
+type ZlibStream struct {
+	Decoder *Decoder
+
+	in      []byte
+	out     []byte
+	window  []byte
+	started bool
+	src     bytes.Reader
+	fr      io.ReadCloser
+}
+
+func NewZlibStream() *ZlibStream {
+	return &ZlibStream{
+		Decoder: newScanDecoder(),
+		window:  make([]byte, 0, zlibWindow*2),
+	}
+}
+
+// Reset discards buffered input and the history window. Call it when the
+// websocket reconnects, since the server starts a new zlib context.
+func (z *ZlibStream) Reset() {
+	z.in = z.in[:0]
+	z.window = z.window[:0]
+	z.started = false
+}
+
+// Inflate buffers frame and, once a complete message has been received,
+// returns its inflated ETF payload. The payload is only valid until the
+// next call. A message inflating to more than Decoder.MaxInflateSize bytes
+// fails with ErrInflateTooLarge; the stream must then be Reset, as after
+// any other error.
+func (z *ZlibStream) Inflate(frame []byte) ([]byte, bool, error) {
+	z.in = append(z.in, frame...)
+	if !bytes.HasSuffix(z.in, zlibSuffix) {
+		return nil, false, nil
+	}
+
+	in := z.in
+	if !z.started {
+		if len(in) < 2 || in[0]&0x0f != 8 || in[1]&0x20 != 0 || (uint16(in[0])<<8|uint16(in[1]))%31 != 0 {
+			z.in = z.in[:0]
+			return nil, false, ErrZlibHeader
+		}
+		in = in[2:]
+		z.started = true
+	}
+
+	z.src.Reset(in)
+	if z.fr == nil {
+		z.fr = flate.NewReaderDict(&z.src, z.window)
+	} else if err := z.fr.(flate.Resetter).Reset(&z.src, z.window); err != nil {
+		return nil, false, err
+	}
+	z.in = z.in[:0]
+
+	limit := z.Decoder.MaxInflateSize
+	z.out = z.out[:0]
+	for {
+		z.out = slices.Grow(z.out, streamChunk)
+		n, err := z.fr.Read(z.out[len(z.out):cap(z.out)])
+		z.out = z.out[:len(z.out)+n]
+		if limit > 0 && len(z.out) > limit {
+			return nil, false, ErrInflateTooLarge
+		}
+		if err == nil {
+			continue
+		}
+		// The sync flush leaves the deflate stream open, so running out of
+		// input right after it is the expected end of a message.
+		if err == io.EOF || err == io.ErrUnexpectedEOF && z.src.Len() == 0 {
+			break
+		}
+		return nil, false, err
+	}
+
+	z.window = append(z.window, z.out...)
+	if len(z.window) > zlibWindow {
+		z.window = append(z.window[:0], z.window[len(z.window)-zlibWindow:]...)
+	}
+
+	return z.out, true, nil
+}
+
+func (z *ZlibStream) Unpack(frame []byte) ([]byte, bool, error) {
+	payload, ok, err := z.Inflate(frame)
+	if !ok || err != nil {
+		return nil, ok, err
+	}
+	out, err := z.Decoder.Unpack(payload)
+	return out, true, err
+}
+
+func (z *ZlibStream) UnpackValue(frame []byte) (any, bool, error) {
+	payload, ok, err := z.Inflate(frame)
+	if !ok || err != nil {
+		return nil, ok, err
+	}
+	out, err := z.Decoder.UnpackValue(payload)
+	return out, true, err
+}
@@ -0,0 +1,159 @@
+package erlpack
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+)
+
+// syncFlushed compresses msgs as one zlib stream, sync flushing after each
+// message the way the gateway does, and returns the bytes of each message.
+func syncFlushed(t *testing.T, msgs ...[]byte) [][]byte {
+	t.Helper()
+
+	var buf bytes.Buffer
+	w := zlib.NewWriter(&buf)
+	var out [][]byte
+	for _, msg := range msgs {
+		if _, err := w.Write(msg); err != nil {
+			t.Fatal(err)
+		}
+		if err := w.Flush(); err != nil {
+			t.Fatal(err)
+		}
+		out = append(out, bytes.Clone(buf.Bytes()))
+		buf.Reset()
+	}
+	return out
+}
+
+func gatewayMessages() [][]byte {
+	e := NewEncoder()
+	long := strings.Repeat("presence update ", 4096)
+	return [][]byte{
+		e.Pack(map[string]any{"op": 10, "d": map[string]any{"heartbeat_interval": 41250}}),
+		e.Pack(map[string]any{"op": 0, "t": "READY", "s": 1, "d": long}),
+		// Repeats the previous message, so it only inflates against the
+		// history window.
+		e.Pack(map[string]any{"op": 0, "t": "READY", "s": 2, "d": long}),
+		e.Pack(map[string]any{"op": 11}),
+	}
+}
+
+func TestZlibStreamSplitFrames(t *testing.T) {
+	msgs := gatewayMessages()
+	z := NewZlibStream()
+
+	for _, size := range []int{1, 7, 1 << 20} {
+		z.Reset()
+		for i, compressed := range syncFlushed(t, msgs...) {
+			for off := 0; off < len(compressed); off += size {
+				end := min(off+size, len(compressed))
+				out, ok, err := z.Inflate(compressed[off:end])
+				if err != nil {
+					t.Fatalf("size %d, message %d: %v", size, i, err)
+				}
+				if ok != (end == len(compressed)) {
+					t.Fatalf("size %d, message %d: ok = %v at %d of %d", size, i, ok, end, len(compressed))
+				}
+				if ok && !bytes.Equal(out, msgs[i]) {
+					t.Fatalf("size %d, message %d: inflated %d bytes, want %d", size, i, len(out), len(msgs[i]))
+				}
+			}
+		}
+	}
+}
+
+func TestZlibStreamUnpack(t *testing.T) {
+	z := NewZlibStream()
+	compressed := syncFlushed(t, gatewayMessages()...)
+
+	out, ok, err := z.Unpack(compressed[0])
+	var hello struct {
+		Op int `json:"op"`
+		D  struct {
+			HeartbeatInterval int `json:"heartbeat_interval"`
+		} `json:"d"`
+	}
+	if err == nil {
+		err = json.Unmarshal(out, &hello)
+	}
+	if err != nil || !ok || hello.Op != 10 || hello.D.HeartbeatInterval != 41250 {
+		t.Errorf("Unpack = %s, %v, %v", out, ok, err)
+	}
+
+	v, ok, err := z.UnpackValue(compressed[1])
+	if m, _ := v.(map[string]any); err != nil || !ok || m["t"] != "READY" {
+		t.Errorf("UnpackValue = %.40v, %v, %v", v, ok, err)
+	}
+}
+
+func TestZlibStreamReset(t *testing.T) {
+	msgs := gatewayMessages()
+	z := NewZlibStream()
+
+	first := syncFlushed(t, msgs[:2]...)
+	for _, c := range first {
+		if _, _, err := z.Inflate(c); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// A partial frame left over from the old connection is discarded too.
+	z.Inflate(first[0][:3])
+
+	// The reconnected stream starts over with a new header and window.
+	z.Reset()
+	for i, c := range syncFlushed(t, msgs[2:]...) {
+		out, ok, err := z.Inflate(c)
+		if err != nil || !ok || !bytes.Equal(out, msgs[2+i]) {
+			t.Fatalf("after Reset, message %d: %d bytes, %v, %v", i, len(out), ok, err)
+		}
+	}
+
+	// Without a Reset the new header is taken as deflate data.
+	if _, _, err := z.Inflate(syncFlushed(t, msgs[0])[0]); err == nil {
+		t.Error("second stream without Reset: no error")
+	}
+}
+
+func TestZlibStreamBadHeader(t *testing.T) {
+	for _, header := range [][]byte{
+		{},
+		{0x78},
+		{0x79, 0x9c},
+		{0x78, 0x9d},
+		{0x78, 0xbb},
+	} {
+		z := NewZlibStream()
+		frame := append(bytes.Clone(header), zlibSuffix...)
+		if _, _, err := z.Inflate(frame); !errors.Is(err, ErrZlibHeader) {
+			t.Errorf("Inflate(% x) = %v, want ErrZlibHeader", frame, err)
+		}
+	}
+}
+
+func TestZlibStreamInflateLimit(t *testing.T) {
+	bomb := make([]byte, 1<<20)
+	small := gatewayMessages()[0]
+
+	z := NewZlibStream()
+	z.Decoder.MaxInflateSize = 64 * 1024
+	if _, _, err := z.Inflate(syncFlushed(t, bomb)[0]); !errors.Is(err, ErrInflateTooLarge) {
+		t.Fatalf("Inflate of %d bytes = %v, want ErrInflateTooLarge", len(bomb), err)
+	}
+	if n := cap(z.out); n > 2*(z.Decoder.MaxInflateSize+streamChunk) {
+		t.Errorf("Inflate buffered %d bytes before failing", n)
+	}
+
+	// A message of exactly the limit is accepted.
+	z.Reset()
+	exact := bomb[:z.Decoder.MaxInflateSize]
+	for i, c := range syncFlushed(t, small, exact) {
+		if _, ok, err := z.Inflate(c); err != nil || !ok {
+			t.Errorf("message %d: %v, %v", i, ok, err)
+		}
+	}
+}